@@ -0,0 +1,137 @@
+package benchmark
+
+import (
+	"bytes"
+	"gnp/pkg/message"
+	"gnp/pkg/transport"
+	"net"
+	"testing"
+)
+
+// 运行：go test -run '^$' -bench 'UDP(Marshal|Unmarshal|Read)' ./benchmark
+
+const (
+	udpPayloadSize = 1200
+	udpBatchSize   = 32
+)
+
+func newTunnelData() *message.ControlMessage {
+	return &message.ControlMessage{
+		Ctl:       message.NewTunnelData,
+		ServiceID: "udp16150",
+		Seq:       1 << 20,
+		MAC:       bytes.Repeat([]byte{1}, 16),
+		Payload: &message.ControlMessage_TunnelData{TunnelData: &message.TunnelData{
+			SessionID: "192.168.100.100:54321",
+			Data:      bytes.Repeat([]byte{'a'}, udpPayloadSize),
+		}},
+	}
+}
+
+func benchmarkUDPMarshal(b *testing.B, compact bool) {
+	msg := newTunnelData()
+	buf := message.GetUDPBuf()
+	defer message.PutUDPBuf(buf)
+	b.SetBytes(udpPayloadSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := message.AppendUDP((*buf)[:0], msg, compact)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUDPMarshalProto(b *testing.B) {
+	benchmarkUDPMarshal(b, false)
+}
+
+func BenchmarkUDPMarshalCompact(b *testing.B) {
+	benchmarkUDPMarshal(b, true)
+}
+
+func benchmarkUDPUnmarshal(b *testing.B, compact bool) {
+	data, err := message.AppendUDP(nil, newTunnelData(), compact)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(udpPayloadSize)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_, err := message.UnmarshalUDP(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUDPUnmarshalProto(b *testing.B) {
+	benchmarkUDPUnmarshal(b, false)
+}
+
+func BenchmarkUDPUnmarshalCompact(b *testing.B) {
+	benchmarkUDPUnmarshal(b, true)
+}
+
+// benchmarkUDPRead 每轮先发送一批数据包到本地回环，再计时读取，对比逐个读取和批量读取的速度
+func benchmarkUDPRead(b *testing.B, read func(conn *net.UDPConn) (int, error)) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	sender, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		_ = sender.Close()
+	}()
+	batch := transport.NewBatchConn(sender)
+	msgs := make([]transport.Message, udpBatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{bytes.Repeat([]byte{'a'}, udpPayloadSize)}
+	}
+	b.SetBytes(udpPayloadSize)
+	b.ResetTimer()
+	for received := 0; received < b.N; {
+		b.StopTimer()
+		err := transport.WriteBatch(batch, msgs)
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+		for round := 0; round < len(msgs); {
+			n, err := read(conn)
+			if err != nil {
+				b.Fatal(err)
+			}
+			round += n
+		}
+		received += len(msgs)
+	}
+}
+
+func BenchmarkUDPReadSingle(b *testing.B) {
+	buf := make([]byte, message.MaxUDPDataSize)
+	benchmarkUDPRead(b, func(conn *net.UDPConn) (int, error) {
+		_, _, err := conn.ReadFrom(buf)
+		return 1, err
+	})
+}
+
+func BenchmarkUDPReadBatch(b *testing.B) {
+	msgs := make([]transport.Message, udpBatchSize)
+	for i := range msgs {
+		msgs[i].Buffers = [][]byte{make([]byte, message.MaxUDPDataSize)}
+	}
+	var batch transport.BatchConn
+	benchmarkUDPRead(b, func(conn *net.UDPConn) (int, error) {
+		if batch == nil {
+			batch = transport.NewBatchConn(conn)
+		}
+		return batch.ReadBatch(msgs)
+	})
+}
@@ -0,0 +1,127 @@
+package gnp
+
+import (
+	"context"
+	"errors"
+	"gnp/client"
+	"gnp/pkg/transport"
+	"sync/atomic"
+)
+
+// Client 嵌入的客户端实例
+type Client struct {
+	conf    ClientConfig
+	handler EventHandler
+	runner  *client.Runner
+	started atomic.Bool
+	cancel  context.CancelFunc
+	// done 客户端退出后关闭
+	done chan struct{}
+	err  error
+}
+
+type ClientOption func(*Client)
+
+// WithClientConfig 设置客户端配置，未设置的选项需要从 DefaultClientConfig 开始修改
+func WithClientConfig(conf ClientConfig) ClientOption {
+	return func(c *Client) {
+		c.conf = conf
+	}
+}
+
+// WithClientEventHandler 设置事件回调
+func WithClientEventHandler(handler EventHandler) ClientOption {
+	return func(c *Client) {
+		c.handler = handler
+	}
+}
+
+// DefaultClientConfig 客户端默认配置，命令行的默认配置也使用这里的值，需要设置代理服务或者访问者
+func DefaultClientConfig() ClientConfig {
+	return ClientConfig{
+		ServerHost:           "localhost",
+		ServerPort:           "6000",
+		ServerStrategy:       client.ServerStrategyOrdered,
+		Transport:            transport.TCP,
+		KCP:                  defaultKCP(),
+		PrimaryCheckInterval: 30,
+		KeepAlivePeriod:      2,
+		KeepAliveMaxFailed:   3,
+		ConnTimeout:          3600,
+		ReconnectInterval:    1,
+		ReconnectMaxInterval: 60,
+		// UDP 会话通常很短，比如 DNS 查询，默认空闲超时时间较短
+		UDPConnTimeout: 60,
+		// UDP 隧道分片默认 MTU，留出传输层封装的开销
+		UDPMTU:             1200,
+		UDPFragmentTimeout: 5,
+		UDPMode:            client.UDPModeAuto,
+		// 常见 NAT 的 UDP 映射超时不低于 30 秒
+		UDPMuxKeepAlivePeriod: 20,
+	}
+}
+
+// NewClient 创建客户端实例，配置错误时返回错误
+func NewClient(opts ...ClientOption) (*Client, error) {
+	c := &Client{
+		conf: DefaultClientConfig(),
+		done: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	err := client.CheckConfig(&c.conf)
+	if err != nil {
+		return nil, err
+	}
+	c.runner = client.NewRunner(c.conf)
+	c.runner.SetEventHandler(c.handler)
+	return c, nil
+}
+
+// Start 在后台连接服务端并保持重连，ctx 结束时注销代理服务并退出
+func (c *Client) Start(ctx context.Context) error {
+	if !c.started.CompareAndSwap(false, true) {
+		return errors.New("client already started")
+	}
+	ctx, c.cancel = context.WithCancel(ctx)
+	go func() {
+		c.err = c.runner.Run(ctx)
+		close(c.done)
+	}()
+	return nil
+}
+
+// Shutdown 注销代理服务并关闭所有隧道，等待客户端退出或者 ctx 结束
+func (c *Client) Shutdown(ctx context.Context) error {
+	if !c.started.Load() {
+		return nil
+	}
+	c.cancel()
+	select {
+	case <-c.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done 客户端退出后关闭，比如超过最大重连次数
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err 客户端退出的原因，正常停止时为空，需要在 Done 关闭后调用
+func (c *Client) Err() error {
+	return c.err
+}
+
+// Reload 重载配置，只有代理服务列表会在当前控制连接上生效，其他配置在重新连接后生效
+func (c *Client) Reload(conf ClientConfig) error {
+	err := client.CheckConfig(&conf)
+	if err != nil {
+		return err
+	}
+	c.runner.Reload(conf)
+	return nil
+}
@@ -0,0 +1,57 @@
+package client
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff 重连退避，每次失败等待时间翻倍，直到最大间隔，并加入随机抖动避免客户端同时重连
+type backoff struct {
+	// interval 初始重连间隔
+	interval time.Duration
+	// maxInterval 最大重连间隔
+	maxInterval time.Duration
+	// maxRetries 最大连续重试次数，0 表示不限制
+	maxRetries int
+	retries    int
+	current    time.Duration
+}
+
+func newBackoff(interval, maxInterval, maxRetries int) *backoff {
+	if interval <= 0 {
+		interval = 1
+	}
+	if maxInterval < interval {
+		maxInterval = interval
+	}
+	return &backoff{
+		interval:    time.Second * time.Duration(interval),
+		maxInterval: time.Second * time.Duration(maxInterval),
+		maxRetries:  maxRetries,
+	}
+}
+
+// next 返回下次重连前的等待时间，超过最大重试次数时返回 false
+func (b *backoff) next() (time.Duration, bool) {
+	b.retries++
+	if b.maxRetries > 0 && b.retries > b.maxRetries {
+		return 0, false
+	}
+	if b.current == 0 {
+		b.current = b.interval
+	} else {
+		b.current *= 2
+		if b.current > b.maxInterval {
+			b.current = b.maxInterval
+		}
+	}
+	// 等待时间在 [current/2, current) 之间随机
+	half := b.current / 2
+	return half + time.Duration(rand.Int63n(int64(b.current-half)+1)), true
+}
+
+// reset 注册成功后重置退避状态
+func (b *backoff) reset() {
+	b.retries = 0
+	b.current = 0
+}
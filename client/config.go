@@ -0,0 +1,112 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"gnp/pkg/config"
+	"gnp/pkg/message"
+	"gnp/pkg/transport"
+)
+
+// CheckConfig 校验客户端配置，命令行和嵌入使用共用
+func CheckConfig(conf *config.ClientConfig) error {
+	if len(conf.Servers) == 0 {
+		if len(conf.ServerHost) == 0 {
+			return errors.New("server host is empty")
+		}
+
+		if len(conf.ServerPort) == 0 {
+			return errors.New("server port is empty")
+		}
+	}
+
+	for _, server := range conf.Servers {
+		if len(server.Host) == 0 || len(server.Port) == 0 {
+			return errors.New("servers host or port is empty")
+		}
+	}
+
+	switch conf.ServerStrategy {
+	case ServerStrategyOrdered, ServerStrategyWeighted:
+	default:
+		return fmt.Errorf("unknown server strategy %s", conf.ServerStrategy)
+	}
+
+	if !transport.IsSupported(conf.Transport) {
+		return fmt.Errorf("unsupported transport %s", conf.Transport)
+	}
+
+	if conf.ConnTimeout <= 0 {
+		return errors.New("conn timeout must be greater than 0")
+	}
+
+	if conf.PreferPrimary && conf.PrimaryCheckInterval <= 0 {
+		return errors.New("primary check interval must be greater than 0")
+	}
+
+	if conf.KeepAlivePeriod <= 0 {
+		return errors.New("keep alive period must be greater than 0")
+	}
+
+	if conf.UDPMTU < message.MinUDPMTU || conf.UDPMTU > message.MaxUDPDataSize {
+		return fmt.Errorf("udp mtu must be between %d and %d", message.MinUDPMTU, message.MaxUDPDataSize)
+	}
+
+	switch conf.UDPMode {
+	case UDPModeAuto, UDPModeUDP, UDPModeTCP:
+	default:
+		return fmt.Errorf("unknown udp mode %s", conf.UDPMode)
+	}
+
+	if conf.UDPConnTimeout <= 0 {
+		return errors.New("udp conn timeout must be greater than 0")
+	}
+
+	if conf.UDPMux && conf.UDPMuxKeepAlivePeriod <= 0 {
+		return errors.New("udp mux keep alive period must be greater than 0")
+	}
+
+	err := transport.CheckProxy(conf)
+	if err != nil {
+		return err
+	}
+
+	if len(conf.Services) == 0 && len(conf.Visitors) == 0 {
+		return errors.New("services is empty")
+	}
+
+	for _, service := range conf.Services {
+		if !message.IsCompressionSupported(service.Compression) {
+			return fmt.Errorf("unsupported compression %s", service.Compression)
+		}
+		if service.Compression != "" && service.Network != "tcp" {
+			return fmt.Errorf("compression is only supported for tcp service %s", service.ProxyPort)
+		}
+		if !message.IsQueuePolicySupported(service.UDPQueuePolicy) {
+			return fmt.Errorf("unsupported udp queue policy %s", service.UDPQueuePolicy)
+		}
+		if service.ConnTimeout < 0 {
+			return fmt.Errorf("conn timeout must not be negative for service %s", service.ProxyPort)
+		}
+		if service.UDPQueueSize < 0 {
+			return fmt.Errorf("udp queue size must not be negative for service %s", service.ProxyPort)
+		}
+		if service.Compression != "" && service.Key != "" {
+			// 服务端只能看到密文，压缩没有效果
+			return fmt.Errorf("compression can not be used with key for service %s", service.ProxyPort)
+		}
+	}
+
+	for _, visitor := range conf.Visitors {
+		if visitor.Network != "tcp" && visitor.Network != "udp" {
+			return fmt.Errorf("unsupported visitor network %s", visitor.Network)
+		}
+		if len(visitor.BindAddr) == 0 || len(visitor.ServerAddr) == 0 {
+			return errors.New("visitor bind addr or server addr is empty")
+		}
+		if len(visitor.Key) == 0 {
+			return fmt.Errorf("visitor key is empty %s", visitor.BindAddr)
+		}
+	}
+	return nil
+}
@@ -4,32 +4,107 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"fmt"
+	"github.com/sanmuyan/xpkg/xcrypto"
 	"github.com/sirupsen/logrus"
 	"gnp/pkg/config"
+	"gnp/pkg/event"
 	"gnp/pkg/message"
-	"gnp/pkg/util"
+	"gnp/pkg/transport"
 	"io"
 	"net"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // Client 客户端控制中心
 type Client struct {
+	// runner 所属的客户端运行实例
+	runner *Runner
 	ctx    context.Context
 	cancel context.CancelFunc
-	Config config.ClientConfig
+	// tunnelCtx 隧道上下文，控制连接断开时已有隧道继续转发
+	tunnelCtx context.Context
+	Config    config.ClientConfig
 	// ctlConn 客户端控制连接
-	ctlConn     net.Conn
+	ctlConn net.Conn
+	// serverAddr 当前连接的服务端地址，隧道连接使用同一个服务端
+	serverAddr string
+	// dialer 连接服务端的传输方式，控制连接和 TCP 隧道连接共用
+	dialer      transport.Dialer
 	keepAliveCh chan struct{}
+	// services 已注册的代理服务
+	services map[string]config.Service
+	mx       sync.Mutex
+	// connected 服务端是否已接受握手和登录，用于重置重连退避，只有访问者或代理服务全部被拒绝时也算连接成功
+	connected atomic.Bool
+	// needFailover 心跳超时、服务端停机或主服务端恢复时需要切换服务端
+	needFailover atomic.Bool
+	// clientID 客户端 ID，重连后服务端根据客户端 ID 恢复代理服务
+	clientID string
+	// hello 握手协商结果
+	hello atomic.Pointer[message.Hello]
+	// onceRegistry 握手完成或者超时后注册代理服务
+	onceRegistry sync.Once
+	// udpOverTCP UDP 隧道数据通过 TCP 隧道连接传输
+	udpOverTCP atomic.Bool
+	// udpModeReady UDP 隧道数据传输方式确定后关闭
+	udpModeReady chan struct{}
+	// udpMuxes 代理服务共用的 UDP 连接
+	udpMuxes map[string]*udpMux
+	udpMuxMx sync.Mutex
 }
 
-func NewClient(ctx context.Context, cancel context.CancelFunc, config config.ClientConfig, ctlConn net.Conn) *Client {
+// handshakeTimeout 等待服务端握手响应的时间，超时后按不支持握手的旧版本服务端处理
+const handshakeTimeout = time.Second * 3
+
+// Runner 客户端运行实例，负责连接服务端、断线重连和重载配置，同一进程可以运行多个实例
+type Runner struct {
+	conf config.ClientConfig
+	// reloadCh 配置重载通知队列
+	reloadCh chan config.ClientConfig
+	// handler 事件回调
+	handler event.Handler
+	// compressStats 各代理服务的隧道数据压缩统计
+	compressStats sync.Map
+}
+
+func NewRunner(conf config.ClientConfig) *Runner {
+	return &Runner{
+		conf:     conf,
+		reloadCh: make(chan config.ClientConfig, 1),
+	}
+}
+
+// SetEventHandler 设置事件回调，需要在 Run 之前调用
+func (r *Runner) SetEventHandler(handler event.Handler) {
+	r.handler = handler
+}
+
+// Reload 通知客户端重载配置，只有代理服务列表会在当前控制连接上生效，其他配置在重新连接后生效
+func (r *Runner) Reload(conf config.ClientConfig) {
+	select {
+	case <-r.reloadCh:
+	default:
+	}
+	r.reloadCh <- conf
+}
+
+func serviceID(service config.Service) string {
+	return service.Network + service.ProxyPort
+}
+
+func NewClient(ctx context.Context, cancel context.CancelFunc, conf config.ClientConfig, ctlConn net.Conn) *Client {
 	return &Client{
-		ctx:         ctx,
-		cancel:      cancel,
-		Config:      config,
-		ctlConn:     ctlConn,
-		keepAliveCh: make(chan struct{}),
+		ctx:          ctx,
+		cancel:       cancel,
+		Config:       conf,
+		ctlConn:      ctlConn,
+		keepAliveCh:  make(chan struct{}),
+		services:     make(map[string]config.Service),
+		udpModeReady: make(chan struct{}),
+		udpMuxes:     make(map[string]*udpMux),
 	}
 }
 
@@ -42,7 +117,8 @@ func (c *Client) keepAlive() {
 	go func() {
 		for range t.C {
 			_ = c.sendMsg(&message.ControlMessage{
-				Ctl: message.KeepAlive,
+				Ctl:     message.KeepAlive,
+				Payload: &message.ControlMessage_Heartbeat{Heartbeat: &message.Heartbeat{SendTime: time.Now().UnixNano()}},
 			})
 		}
 	}()
@@ -54,6 +130,8 @@ func (c *Client) keepAlive() {
 			count += 1
 			if count > c.Config.KeepAliveMaxFailed {
 				logrus.Errorln("keep alive max timeout")
+				c.needFailover.Store(true)
+				c.dialer.Reset(c.serverAddr)
 				return
 			}
 		case <-c.keepAliveCh:
@@ -64,21 +142,143 @@ func (c *Client) keepAlive() {
 
 // registryService 请求服务器注册代理服务
 func (c *Client) registryService() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
 	for _, item := range c.Config.Services {
-		msg := &message.ControlMessage{
-			Ctl: message.NewService,
-			Service: &message.Service{
-				ProxyPort: item.ProxyPort,
-				LocalAddr: item.LocalAddr,
-				Network:   item.Network,
-			},
-			ServiceID: item.Network + item.ProxyPort,
+		c.newService(item)
+	}
+}
+
+// handshake 向服务端发送握手消息，等待协商结果后注册代理服务
+func (c *Client) handshake() {
+	err := c.sendMsg(&message.ControlMessage{
+		Ctl:     message.Handshake,
+		Payload: &message.ControlMessage_Hello{Hello: message.NewHello()},
+	})
+	if err != nil {
+		logrus.Errorf("send handshake message %v", err)
+		c.cancel()
+		return
+	}
+	select {
+	case <-c.ctx.Done():
+	case <-time.After(handshakeTimeout):
+		c.onceRegistry.Do(func() {
+			logrus.Warnf("server does not support handshake, use legacy protocol")
+			c.hello.Store(message.LegacyHello)
+			go c.initUDPMode()
+			go c.registryService()
+		})
+	}
+}
+
+// handelHandshake 处理服务端握手响应，协商失败时返回 false 关闭控制连接
+func (c *Client) handelHandshake(msg *message.ControlMessage) bool {
+	if msg.GetHello().GetError() != "" {
+		logrus.Errorf("handshake failed %s server version=%s", msg.GetHello().GetError(), msg.GetHello().GetVersion())
+		return false
+	}
+	c.connected.Store(true)
+	c.onceRegistry.Do(func() {
+		logrus.Infof("handshake server version=%s protocol=%d capabilities=%v", msg.GetHello().GetVersion(), msg.GetHello().GetProtocolVersion(), msg.GetHello().GetCapabilities())
+		c.hello.Store(msg.GetHello())
+		go c.initUDPMode()
+		go c.registryService()
+	})
+	return true
+}
+
+// newService 注册单个代理服务，调用方需要持有锁
+func (c *Client) newService(item config.Service) {
+	if !c.hello.Load().HasCapability(item.Network) {
+		logrus.Warnf("[%s] server not supported network %s", serviceID(item), item.Network)
+		return
+	}
+	// 端到端加密不能降级为明文，服务端不支持时不注册
+	if item.Key != "" && !c.hello.Load().HasCapability(message.CapEncryption) {
+		logrus.Warnf("[%s] server not supported encryption", serviceID(item))
+		return
+	}
+	msg := &message.ControlMessage{
+		Ctl: message.NewService,
+		Payload: &message.ControlMessage_Register{Register: &message.Register{Service: &message.Service{
+			ProxyPort: item.ProxyPort,
+			LocalAddr: item.LocalAddr,
+			Network:   item.Network,
+			// 旧版本服务端忽略超时设置
+			ConnTimeout: uint32(item.ConnTimeout),
+		}}},
+		ServiceID: serviceID(item),
+	}
+	if item.Network == "udp" {
+		// 旧版本服务端忽略队列设置
+		msg.GetRegister().GetService().UDPQueueSize = uint32(item.UDPQueueSize)
+		msg.GetRegister().GetService().UDPQueuePolicy = item.UDPQueuePolicy
+	}
+	if item.Compression != "" {
+		if c.hello.Load().HasCapability(message.CapCompression) {
+			msg.GetRegister().GetService().Compression = item.Compression
+		} else {
+			logrus.Warnf("[%s] server not supported compression", serviceID(item))
+		}
+	}
+	err := c.sendMsg(msg)
+	if err != nil {
+		logrus.Errorf("[%s] send control message %v", msg.GetServiceID(), err)
+		return
+	}
+	c.services[msg.GetServiceID()] = item
+	logrus.Infof("[%s] registry service %s", msg.GetServiceID(), item.LocalAddr)
+}
+
+// getService 获取已注册代理服务的本地配置
+func (c *Client) getService(id string) config.Service {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	return c.services[id]
+}
+
+// closeService 注销单个代理服务，调用方需要持有锁
+func (c *Client) closeService(id string) {
+	err := c.sendMsg(&message.ControlMessage{
+		Ctl:       message.CloseService,
+		ServiceID: id,
+	})
+	if err != nil {
+		logrus.Errorf("[%s] send control message %v", id, err)
+		return
+	}
+	delete(c.services, id)
+	logrus.Infof("[%s] unregister service", id)
+}
+
+// closeServices 注销所有代理服务
+func (c *Client) closeServices() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	for id := range c.services {
+		c.closeService(id)
+	}
+}
+
+// reloadServices 对比新的代理服务列表，只注销和注册发生变化的服务
+func (c *Client) reloadServices(services []config.Service) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	c.Config.Services = services
+	newServices := make(map[string]config.Service)
+	for _, item := range services {
+		newServices[serviceID(item)] = item
+	}
+	for id, item := range c.services {
+		if newItem, ok := newServices[id]; !ok || newItem != item {
+			c.closeService(id)
 		}
-		err := c.sendMsg(msg)
-		if err != nil {
-			logrus.Errorf("[%s] send control message %v", msg.GetService(), err)
+	}
+	for id, item := range newServices {
+		if _, ok := c.services[id]; !ok {
+			c.newService(item)
 		}
-		logrus.Infof("[%s] registry service %s", msg.GetServiceID(), item.LocalAddr)
 	}
 }
 
@@ -91,9 +291,19 @@ func (c *Client) auth(msg *message.ControlMessage) bool {
 
 func (c *Client) sendMsg(msg *message.ControlMessage) error {
 	msg.Token = c.Config.Token
+	msg.User = c.Config.User
+	msg.ClientID = c.clientID
+	c.compat(msg)
 	return message.WriteTCP(msg, c.ctlConn)
 }
 
+// compat 服务端只支持旧版本协议时，把消息转换为共享字段
+func (c *Client) compat(msg *message.ControlMessage) {
+	if hello := c.hello.Load(); hello != nil && message.IsLegacy(hello) {
+		message.Downgrade(msg)
+	}
+}
+
 // controller 处理服务端控制消息
 func (c *Client) controller() {
 	defer func() {
@@ -120,17 +330,50 @@ func (c *Client) controller() {
 				continue
 			}
 			switch msg.GetCtl() {
+			case message.Handshake:
+				if !c.handelHandshake(msg) {
+					c.needFailover.Store(true)
+					return
+				}
 			case message.ServiceReady:
 				logrus.Infof("[%s] registry service ready", msg.GetServiceID())
+				c.runner.handler.Emit(event.Event{Type: event.ServiceRegistered, ServiceID: msg.GetServiceID(), Addr: c.serverAddr})
+				if compression := msg.GetReady().GetService().GetCompression(); compression != "" {
+					logrus.Infof("[%s] tunnel compression %s", msg.GetServiceID(), compression)
+				}
+				c.connected.Store(true)
 			case message.NewTunnel:
-				logrus.Infof("[%s] new tunnel sessionID:=%s", msg.GetServiceID(), msg.GetSessionID())
-				switch msg.GetService().GetNetwork() {
+				logrus.Infof("[%s] new tunnel sessionID:=%s", msg.GetServiceID(), msg.GetTunnel().GetSessionID())
+				c.runner.handler.Emit(event.Event{Type: event.SessionOpened, ServiceID: msg.GetServiceID(), SessionID: msg.GetTunnel().GetSessionID(), Addr: c.serverAddr})
+				switch msg.GetTunnel().GetService().GetNetwork() {
 				case "tcp":
-					go NewTCPTunnel(NewTunnel(c.ctx, c, msg)).NewTunnel()
+					go NewTCPTunnel(NewTunnel(c.tunnelCtx, c, msg)).NewTunnel()
 				case "udp":
-					go NewUDPTunnel(NewTunnel(c.ctx, c, msg)).NewTunnel()
+					go NewUDPTunnel(NewTunnel(c.tunnelCtx, c, msg)).NewTunnel()
 				}
+			case message.ServiceRejected:
+				logrus.Warnf("[%s] registry service rejected: %s", msg.GetServiceID(), msg.GetRejected().GetReason())
+				c.runner.handler.Emit(event.Event{Type: event.ServiceRejected, ServiceID: msg.GetServiceID(), Addr: c.serverAddr, Reason: msg.GetRejected().GetReason()})
+				c.mx.Lock()
+				delete(c.services, msg.GetServiceID())
+				c.mx.Unlock()
+			case message.CloseService:
+				logrus.Warnf("[%s] service closed by server: %s", msg.GetServiceID(), msg.GetRejected().GetReason())
+				c.runner.handler.Emit(event.Event{Type: event.ServiceClosed, ServiceID: msg.GetServiceID(), Addr: c.serverAddr, Reason: msg.GetRejected().GetReason()})
+				c.mx.Lock()
+				delete(c.services, msg.GetServiceID())
+				c.mx.Unlock()
+			case message.ServerShutdown:
+				// 服务端停机排空，已有隧道继续转发，控制连接重新连接
+				logrus.Warnf("server is shutting down %s", c.ctlConn.RemoteAddr().String())
+				c.needFailover.Store(true)
+				return
 			case message.KeepAlive:
+				// 旧版本服务端不响应握手，只回复登录校验通过的心跳
+				c.connected.Store(true)
+				if sendTime := msg.GetHeartbeat().GetSendTime(); sendTime > 0 {
+					logrus.Tracef("keep alive rtt %s", time.Duration(time.Now().UnixNano()-sendTime))
+				}
 				c.keepAliveCh <- struct{}{}
 			default:
 				logrus.Warnf("[%s] unknown ctl:=%d", msg.GetServiceID(), msg.GetCtl())
@@ -139,34 +382,117 @@ func (c *Client) controller() {
 	}
 }
 
-func run(ctx context.Context) {
+// run 建立控制连接并处理消息，返回是否连接成功以及是否需要切换服务端
+func (r *Runner) run(ctx context.Context, conf *config.ClientConfig, pool *serverPool, dialer transport.Dialer, clientID string) (bool, bool) {
+	tunnelCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
-	addr := net.JoinHostPort(config.ClientConf.ServerHost, config.ClientConf.ServerPort)
-	conn, err := util.CreateDialTCP(addr)
+	addr := pool.current()
+	conn, err := dialer.Dial(addr)
 	if err != nil {
 		logrus.Errorf("connect server %s %v", addr, err)
-		return
+		return false, true
 	}
 	defer func() {
 		_ = conn.Close()
 	}()
-	client := NewClient(ctx, cancel, config.ClientConf, conn)
-	go client.registryService()
+	client := NewClient(ctx, cancel, *conf, conn)
+	client.runner = r
+	client.tunnelCtx = tunnelCtx
+	client.serverAddr = addr
+	client.dialer = dialer
+	client.clientID = clientID
+	r.handler.Emit(event.Event{Type: event.Connected, Addr: addr})
+	go client.handshake()
 	go client.keepAlive()
 	go client.controller()
-	<-ctx.Done()
+	go client.watchPrimary(pool)
+	for {
+		select {
+		case <-ctx.Done():
+			if tunnelCtx.Err() != nil {
+				// 客户端退出时主动注销代理服务，服务端不需要等待恢复
+				client.closeServices()
+			}
+			r.handler.Emit(event.Event{Type: event.Disconnected, Addr: addr})
+			connected := client.connected.Load()
+			return connected, !connected || client.needFailover.Load()
+		case newConf := <-r.reloadCh:
+			logrus.Info("reload services")
+			*conf = newConf
+			client.reloadServices(newConf.Services)
+		}
+	}
 }
 
-func Run(ctx context.Context) {
+// Run 连接服务端并在断开后重连，ctx 结束时注销代理服务并返回，超过最大重连次数时返回错误
+func (r *Runner) Run(ctx context.Context) error {
+	conf := r.conf
+	b := newBackoff(conf.ReconnectInterval, conf.ReconnectMaxInterval, conf.ReconnectMaxRetries)
+	pool := newServerPool(&conf)
+	// 进程内保持不变，控制连接断开重连后服务端可以恢复代理服务和已有会话
+	clientID := xcrypto.GenerateRandomString(32, true, true, true, false)
+	dialer, err := transport.NewDialer(&conf)
+	if err != nil {
+		return err
+	}
+	if len(conf.Proxy.Addr) > 0 && conf.UDPMode != UDPModeTCP {
+		for _, service := range conf.Services {
+			if service.Network == "udp" {
+				logrus.Warnf("proxy does not support udp, service %s connect server directly unless udp tunnel use tcp", service.ProxyPort)
+			}
+		}
+	}
+	defer func() {
+		_ = dialer.Close()
+	}()
+	// 访问者不依赖控制连接，配置修改后需要重启生效
+	for _, visitor := range conf.Visitors {
+		connTimeout := conf.ConnTimeout
+		if visitor.Network == "udp" {
+			connTimeout = conf.UDPConnTimeout
+		}
+		go NewVisitor(ctx, visitor, connTimeout).Start()
+	}
 	for {
 		select {
 		case <-ctx.Done():
-			return
+			return nil
 		default:
-			logrus.Infof("connect server %s:%s", config.ClientConf.ServerHost, config.ClientConf.ServerPort)
-			run(ctx)
-			time.Sleep(time.Second)
+			logrus.Infof("connect server %s", pool.current())
+			transportName, proxy := conf.Transport, conf.Proxy
+			interval, maxInterval, maxRetries := conf.ReconnectInterval, conf.ReconnectMaxInterval, conf.ReconnectMaxRetries
+			connected, failover := r.run(ctx, &conf, pool, dialer, clientID)
+			if conf.ReconnectInterval != interval || conf.ReconnectMaxInterval != maxInterval || conf.ReconnectMaxRetries != maxRetries {
+				// 重连配置变化后按新配置重新计算退避
+				b = newBackoff(conf.ReconnectInterval, conf.ReconnectMaxInterval, conf.ReconnectMaxRetries)
+			} else if connected {
+				b.reset()
+			}
+			pool.update(&conf)
+			if conf.Transport != transportName || conf.Proxy != proxy {
+				// 传输方式或代理变化后已有隧道无法继续使用，重新创建
+				newDialer, err := transport.NewDialer(&conf)
+				if err != nil {
+					return err
+				}
+				_ = dialer.Close()
+				dialer = newDialer
+			}
+			// 连接成功后连接断开优先重连同一个服务端，以便恢复会话
+			if failover {
+				pool.failover()
+			}
+			d, ok := b.next()
+			if !ok {
+				return fmt.Errorf("reconnect server exceeded max retries %d", conf.ReconnectMaxRetries)
+			}
+			logrus.Infof("reconnect server after %s", d)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(d):
+			}
 		}
 	}
 }
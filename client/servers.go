@@ -0,0 +1,161 @@
+package client
+
+import (
+	"github.com/sirupsen/logrus"
+	"gnp/pkg/config"
+	"math/rand"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// ServerStrategyOrdered 按配置顺序选择服务端，失败后切换到下一个
+	ServerStrategyOrdered = "ordered"
+	// ServerStrategyWeighted 按权重随机选择服务端，失败后切换到其他服务端
+	ServerStrategyWeighted = "weighted"
+)
+
+// serverPool 服务端列表，处理服务端选择和故障切换
+type serverPool struct {
+	mx       sync.Mutex
+	servers  []config.Server
+	strategy string
+	// index 当前连接的服务端
+	index int
+	// recovered 主服务端已恢复，下次连接回到主服务端
+	recovered bool
+}
+
+// getServers 获取配置的服务端列表，未配置 servers 时使用 server_host 和 server_port
+func getServers(conf *config.ClientConfig) []config.Server {
+	if len(conf.Servers) > 0 {
+		return conf.Servers
+	}
+	return []config.Server{{Host: conf.ServerHost, Port: conf.ServerPort}}
+}
+
+func newServerPool(conf *config.ClientConfig) *serverPool {
+	p := new(serverPool)
+	p.update(conf)
+	return p
+}
+
+// update 配置变化时重新加载服务端列表
+func (p *serverPool) update(conf *config.ClientConfig) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	servers := getServers(conf)
+	if reflect.DeepEqual(servers, p.servers) && p.strategy == conf.ServerStrategy {
+		return
+	}
+	p.servers = servers
+	p.strategy = conf.ServerStrategy
+	p.index = 0
+	p.recovered = false
+	if p.strategy == ServerStrategyWeighted {
+		p.index = p.pickWeighted(-1)
+	}
+}
+
+// current 获取当前服务端地址
+func (p *serverPool) current() string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.addr(p.index)
+}
+
+// primary 获取主服务端地址，如果当前已连接主服务端返回空
+func (p *serverPool) primary() string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if p.index == 0 {
+		return ""
+	}
+	return p.addr(0)
+}
+
+func (p *serverPool) addr(i int) string {
+	return net.JoinHostPort(p.servers[i].Host, p.servers[i].Port)
+}
+
+// failover 连接断开后切换服务端
+func (p *serverPool) failover() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	if len(p.servers) < 2 {
+		return
+	}
+	if p.recovered {
+		p.recovered = false
+		p.index = 0
+		return
+	}
+	switch p.strategy {
+	case ServerStrategyWeighted:
+		p.index = p.pickWeighted(p.index)
+	default:
+		p.index = (p.index + 1) % len(p.servers)
+	}
+	logrus.Infof("failover to server %s", p.addr(p.index))
+}
+
+// setRecovered 标记主服务端已恢复
+func (p *serverPool) setRecovered() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.recovered = true
+}
+
+// pickWeighted 按权重随机选择服务端，排除 exclude，权重小于等于 0 时按 1 计算
+func (p *serverPool) pickWeighted(exclude int) int {
+	var total int
+	for i, server := range p.servers {
+		if i != exclude {
+			total += max(server.Weight, 1)
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	n := rand.Intn(total)
+	for i, server := range p.servers {
+		if i == exclude {
+			continue
+		}
+		n -= max(server.Weight, 1)
+		if n < 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// watchPrimary 连接备用服务端时定时检查主服务端，恢复后关闭当前控制连接回到主服务端
+func (c *Client) watchPrimary(pool *serverPool) {
+	primary := pool.primary()
+	if !c.Config.PreferPrimary || len(primary) == 0 {
+		return
+	}
+	t := time.NewTicker(time.Second * time.Duration(c.Config.PrimaryCheckInterval))
+	defer t.Stop()
+	for {
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-t.C:
+			conn, err := c.dialer.Dial(primary)
+			if err != nil {
+				logrus.Debugf("primary server %s is unavailable %v", primary, err)
+				continue
+			}
+			_ = conn.Close()
+			logrus.Infof("primary server %s recovered", primary)
+			pool.setRecovered()
+			c.needFailover.Store(true)
+			c.cancel()
+			return
+		}
+	}
+}
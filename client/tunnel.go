@@ -3,6 +3,7 @@ package client
 import (
 	"context"
 	"github.com/sirupsen/logrus"
+	"gnp/pkg/event"
 	"gnp/pkg/message"
 	"gnp/pkg/util"
 	"net"
@@ -20,6 +21,8 @@ type Tunnel struct {
 	tunnelConn net.Conn
 	// localConn 本地服务连接
 	localConn net.Conn
+	// connTimeout 会话空闲超时时间，新建隧道时按代理服务确定
+	connTimeout int
 	// onceClose 避免重复关闭引发异常
 	onceClose sync.Once
 	// tunnelToLocalF 新建隧道连接
@@ -42,9 +45,22 @@ func NewTunnel(ctx context.Context, control *Client, ctlMsg *message.ControlMess
 	}
 }
 
+// GetSessionID 获取隧道对应的用户会话 ID
+func (t *Tunnel) GetSessionID() string {
+	return t.ctlMsg.GetTunnel().GetSessionID()
+}
+
+// GetService 获取隧道对应的代理服务
+func (t *Tunnel) GetService() *message.Service {
+	return t.ctlMsg.GetTunnel().GetService()
+}
+
 func (t *Tunnel) ResetTimeout() {
-	_ = util.SetReadDeadline(t.localConn)(t.Config.ConnTimeout)
-	_ = util.SetReadDeadline(t.tunnelConn)(t.Config.ConnTimeout)
+	_ = util.SetReadDeadline(t.localConn)(t.connTimeout)
+	// UDP 会话共用连接时没有单独的隧道连接
+	if t.tunnelConn != nil {
+		_ = util.SetReadDeadline(t.tunnelConn)(t.connTimeout)
+	}
 }
 
 func (t *Tunnel) Close() {
@@ -59,8 +75,22 @@ func (t *Tunnel) Close() {
 	})
 }
 
+// getConnTimeout 代理服务没有设置时按服务类型使用客户端配置
+func (t *Tunnel) getConnTimeout() int {
+	if timeout := t.getService(t.ctlMsg.GetServiceID()).ConnTimeout; timeout > 0 {
+		return timeout
+	}
+	if t.GetService().GetNetwork() == "udp" {
+		return t.Config.UDPConnTimeout
+	}
+	return t.Config.ConnTimeout
+}
+
 func (t *Tunnel) process() {
 	defer t.Close()
+	// 建立连接失败时也通知会话关闭
+	defer t.runner.handler.Emit(event.Event{Type: event.SessionClosed, ServiceID: t.ctlMsg.GetServiceID(), SessionID: t.GetSessionID(), Addr: t.serverAddr})
+	t.connTimeout = t.getConnTimeout()
 	if !t.newTunnelConnF() {
 		return
 	}
@@ -73,5 +103,5 @@ func (t *Tunnel) process() {
 	// 转发本地服务数据到隧道
 	go t.localToTunnelF()
 	<-t.ctx.Done()
-	logrus.Debugf("[%s] close tunnel sessionID=%s", t.ctlMsg.GetServiceID(), t.ctlMsg.GetSessionID())
+	logrus.Debugf("[%s] close tunnel sessionID=%s", t.ctlMsg.GetServiceID(), t.GetSessionID())
 }
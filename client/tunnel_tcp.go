@@ -1,14 +1,16 @@
 package client
 
 import (
+	"errors"
 	"github.com/sirupsen/logrus"
 	"gnp/pkg/message"
 	"gnp/pkg/util"
-	"net"
 )
 
 type TCPTunnel struct {
 	*Tunnel
+	// compressStats 隧道数据压缩统计，代理服务启用压缩时有效
+	compressStats *message.CompressStats
 }
 
 func NewTCPTunnel(tunnel *Tunnel) *TCPTunnel {
@@ -23,15 +25,18 @@ func (t *TCPTunnel) NewTunnel() {
 	t.tunnelToLocalF = t.tunnelToLocal
 	t.localToTunnelF = t.localToTunnel
 	t.process()
+	if t.compressStats != nil {
+		total, _ := t.runner.compressStats.Load(t.ctlMsg.GetServiceID())
+		logrus.Debugf("[%s] compression sessionID=%s %s total %s", t.ctlMsg.GetServiceID(), t.GetSessionID(), t.compressStats, total)
+	}
 }
 
 func (t *TCPTunnel) newTunnelConn() bool {
-	if t.ctlMsg.GetSessionID() == "" {
+	if t.GetSessionID() == "" {
 		logrus.Errorf("[%s] sessionID is empty", t.ctlMsg.GetServiceID())
 		return false
 	}
-	addr := net.JoinHostPort(t.Config.ServerHost, t.Config.ServerPort)
-	tunnelConn, err := util.CreateDialTCP(addr)
+	tunnelConn, err := t.dialer.Dial(t.serverAddr)
 	if err != nil {
 		logrus.Errorf("[%s] tunnel conn connect %v", t.ctlMsg.GetServiceID(), err)
 		return false
@@ -39,22 +44,38 @@ func (t *TCPTunnel) newTunnelConn() bool {
 	t.tunnelConn = tunnelConn
 	msg := &message.ControlMessage{
 		Ctl:       message.NewTunnel,
-		Service:   t.ctlMsg.GetService(),
 		ServiceID: t.ctlMsg.GetServiceID(),
-		SessionID: t.ctlMsg.GetSessionID(),
 		Token:     t.ctlMsg.GetToken(),
+		User:      t.Config.User,
+		Payload:   t.ctlMsg.GetPayload(),
 	}
+	t.compat(msg)
 	err = message.WriteTCP(msg, t.tunnelConn)
 	if err != nil {
 		logrus.Errorf("[%s] send ctl message %v", t.ctlMsg.GetServiceID(), err)
 		return false
 	}
+	if compression := t.GetService().GetCompression(); compression != "" {
+		// 压缩算法以服务端下发的代理服务信息为准
+		total, _ := t.runner.compressStats.LoadOrStore(t.ctlMsg.GetServiceID(), new(message.CompressStats))
+		t.compressStats = new(message.CompressStats)
+		conn, err := message.NewCompressConn(t.tunnelConn, compression, t.compressStats, total.(*message.CompressStats))
+		if err != nil {
+			logrus.Errorf("[%s] compress tunnel %v", t.ctlMsg.GetServiceID(), err)
+			return false
+		}
+		t.tunnelConn = conn
+	}
+	if key := t.getService(t.ctlMsg.GetServiceID()).Key; key != "" {
+		// 端到端加密，服务端只转发密文
+		t.tunnelConn = message.NewCipherConn(t.tunnelConn, key, true)
+	}
 	return true
 }
 
 func (t *TCPTunnel) newLocalConn() bool {
 	var err error
-	t.localConn, err = util.CreateDialTCP(t.ctlMsg.Service.LocalAddr)
+	t.localConn, err = util.CreateDialTCP(t.GetService().GetLocalAddr())
 	if err != nil {
 		logrus.Errorf("[%s] local connect %v", t.ctlMsg.GetServiceID(), err)
 		return false
@@ -65,6 +86,10 @@ func (t *TCPTunnel) newLocalConn() bool {
 func (t *TCPTunnel) tunnelToLocal() {
 	defer t.Close()
 	err := message.Copy(t.localConn, t.tunnelConn, t.ResetTimeout)
+	if errors.Is(err, message.ErrDecrypt) {
+		logrus.Warnf("[%s] tunnel to user %v", t.ctlMsg.GetServiceID(), err)
+		return
+	}
 	if err != nil {
 		logrus.Tracef("[%s] tunnel to user %v", t.ctlMsg.GetServiceID(), err)
 	}
@@ -1,19 +1,37 @@
 package client
 
 import (
+	"bufio"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"gnp/pkg/message"
+	"gnp/pkg/transport"
 	"gnp/pkg/util"
-	"net"
+	"time"
 )
 
 type UDPTunnel struct {
 	*Tunnel
+	// cipher 端到端加密 UDP 数据，代理服务配置密钥时有效
+	cipher *message.PacketCipher
+	// auth 使用服务端下发的会话密钥签名和校验数据包，服务端不支持或者通过 TCP 隧道连接传输时为空
+	auth *message.PacketAuth
+	// reassembler 重组服务端发送的分片
+	reassembler *message.Reassembler
+	// fragID 发送给服务端的分片数据包 ID
+	fragID uint32
+	// reader 通过 TCP 隧道连接传输时读取按长度分帧的隧道数据，使用 UDP 传输时为空
+	reader *bufio.Reader
+	// mux 代理服务共用的 UDP 连接，不共用时为空
+	mux *udpMux
+	// muxCh 共用连接分发给当前会话的隧道数据
+	muxCh chan *message.ControlMessage
 }
 
 func NewUDPTunnel(tunnel *Tunnel) *UDPTunnel {
 	return &UDPTunnel{
-		Tunnel: tunnel,
+		Tunnel:      tunnel,
+		reassembler: message.NewReassembler(time.Second * time.Duration(tunnel.Config.UDPFragmentTimeout)),
 	}
 }
 
@@ -23,27 +41,65 @@ func (t *UDPTunnel) NewTunnel() {
 	t.tunnelToLocalF = t.tunnelToLocal
 	t.localToTunnelF = t.localToTunnel
 	t.process()
+	if t.mux != nil {
+		t.mux.remove(t.GetSessionID(), t.muxCh)
+	}
 }
 
 func (t *UDPTunnel) newTunnelConn() bool {
-	if t.ctlMsg.GetSessionID() == "" {
+	if t.GetSessionID() == "" {
 		logrus.Errorf("[%s] sessionID is empty", t.ctlMsg.GetServiceID())
 		return false
 	}
-	tunnelConn, err := util.CreateDialUDP(net.JoinHostPort(t.Config.ServerHost, t.Config.ServerPort))
+	select {
+	case <-t.ctx.Done():
+		return false
+	case <-t.udpModeReady:
+	}
+	var err error
+	switch {
+	case t.udpOverTCP.Load():
+		t.tunnelConn, err = t.dialer.Dial(t.serverAddr)
+		if err == nil {
+			t.reader = bufio.NewReaderSize(t.tunnelConn, message.ReadBufferSize)
+		}
+	case t.Config.UDPMux && t.hello.Load().HasCapability(message.CapMux):
+		// 共用连接时会话不单独建立连接，服务端按 SessionID 区分会话
+		t.mux, err = t.getUDPMux(t.ctlMsg.GetServiceID())
+		if err == nil {
+			t.muxCh = t.mux.add(t.GetSessionID())
+		}
+	default:
+		t.tunnelConn, err = t.dialer.DialPacket(t.serverAddr)
+	}
 	if err != nil {
 		logrus.Errorf("[%s] tunnel conn connect %v", t.ctlMsg.GetServiceID(), err)
 		return false
 	}
-	t.tunnelConn = tunnelConn
+	if key := t.getService(t.ctlMsg.GetServiceID()).Key; key != "" {
+		t.cipher, err = message.NewPacketCipher(key, true)
+		if err != nil {
+			logrus.Errorf("[%s] create cipher %v", t.ctlMsg.GetServiceID(), err)
+			return false
+		}
+	}
+	if key := t.ctlMsg.GetTunnel().GetKey(); len(key) > 0 && t.reader == nil {
+		t.auth = message.NewPacketAuth(key, false)
+	}
+	// 会话密钥不能通过 UDP 发送
 	msg := &message.ControlMessage{
 		Ctl:       message.NewTunnel,
-		Service:   t.ctlMsg.GetService(),
 		ServiceID: t.ctlMsg.GetServiceID(),
-		SessionID: t.ctlMsg.GetSessionID(),
 		Token:     t.ctlMsg.GetToken(),
+		User:      t.Config.User,
+		Payload: &message.ControlMessage_Tunnel{Tunnel: &message.Tunnel{
+			Service:   t.GetService(),
+			SessionID: t.GetSessionID(),
+		}},
 	}
-	err = message.WriteUDP(msg, t.tunnelConn)
+	t.compat(msg)
+	t.sign(msg)
+	err = t.writeMsg(msg)
 	if err != nil {
 		logrus.Errorf("[%s] send ctl message %v", t.ctlMsg.GetServiceID(), err)
 		return false
@@ -53,7 +109,7 @@ func (t *UDPTunnel) newTunnelConn() bool {
 
 func (t *UDPTunnel) newLocalConn() bool {
 	var err error
-	t.localConn, err = util.CreateDialUDP(t.ctlMsg.Service.LocalAddr)
+	t.localConn, err = util.CreateDialUDP(t.GetService().GetLocalAddr())
 	if err != nil {
 		logrus.Errorf("[%s] local connect %v", t.ctlMsg.GetServiceID(), err)
 		return false
@@ -64,16 +120,49 @@ func (t *UDPTunnel) newLocalConn() bool {
 func (t *UDPTunnel) tunnelToLocal() {
 	defer t.Close()
 	for {
-		msg, err := message.ReadUDP(t.tunnelConn)
+		msg, err := t.readMsg()
 		if err != nil {
 			logrus.Tracef("[%s] tunnel to user %v", t.ctlMsg.GetServiceID(), err)
 			return
 		}
-		if msg.GetCtl() != message.NewTunnelData || msg.GetServiceID() != t.ctlMsg.GetServiceID() || msg.GetSessionID() != t.ctlMsg.GetSessionID() {
+		if msg.GetCtl() != message.NewTunnelData || msg.GetServiceID() != t.ctlMsg.GetServiceID() || msg.GetTunnelData().GetSessionID() != t.GetSessionID() {
 			logrus.Warnf("[%s] tunnel data invalid", t.ctlMsg.GetServiceID())
 			continue
 		}
-		_, err = t.localConn.Write(msg.Data)
+		if t.auth != nil {
+			err = t.auth.Verify(msg)
+			if err != nil {
+				logrus.Debugf("[%s] drop tunnel data sessionID=%s %v", t.ctlMsg.GetServiceID(), t.GetSessionID(), err)
+				continue
+			}
+		}
+		// 分片收齐后才发送给本地服务
+		data, ok := t.reassembler.Add(msg.GetTunnelData())
+		if !ok {
+			continue
+		}
+		if t.cipher != nil {
+			var reply []byte
+			data, reply, err = t.cipher.Open(data)
+			if err != nil {
+				logrus.Warnf("[%s] decrypt tunnel data %v", t.ctlMsg.GetServiceID(), err)
+				continue
+			}
+			if reply != nil {
+				// 握手响应不需要分片
+				msg := t.newDataMsg(reply)
+				t.sign(msg)
+				err = t.writeMsg(msg)
+				if err != nil {
+					logrus.Tracef("[%s] tunnel to user %v", t.ctlMsg.GetServiceID(), err)
+					return
+				}
+			}
+			if data == nil {
+				continue
+			}
+		}
+		_, err = t.localConn.Write(data)
 		if err != nil {
 			logrus.Tracef("[%s] tunnel to user %v", t.ctlMsg.GetServiceID(), err)
 			return
@@ -84,23 +173,94 @@ func (t *UDPTunnel) tunnelToLocal() {
 
 func (t *UDPTunnel) localToTunnel() {
 	defer t.Close()
+	buf := message.GetUDPBuf()
+	defer message.PutUDPBuf(buf)
+	readBuf := *buf
+	if t.cipher != nil {
+		// 加密后的数据不能超过服务端重组的长度
+		readBuf = readBuf[:message.MaxUDPDataSize-message.PacketCipherOverhead]
+	}
 	for {
-		buf := make([]byte, message.BufDataSize)
-		n, err := t.localConn.Read(buf)
+		n, err := t.localConn.Read(readBuf)
 		if err != nil {
 			logrus.Tracef("[%s] user to tunnel %v", t.ctlMsg.GetServiceID(), err)
 			return
 		}
-		err = message.WriteUDP(&message.ControlMessage{
-			Ctl:       message.NewTunnelData,
-			ServiceID: t.ctlMsg.GetServiceID(),
-			SessionID: t.ctlMsg.GetSessionID(),
-			Data:      buf[:n],
-		}, t.tunnelConn)
-		if err != nil {
-			logrus.Tracef("[%s] user to tunnel %v", t.ctlMsg.GetServiceID(), err)
-			return
+		data := readBuf[:n]
+		if t.cipher != nil {
+			data, err = t.cipher.Seal(data)
+			if errors.Is(err, message.ErrPacketHandshake) {
+				logrus.Debugf("[%s] drop local data before visitor handshake sessionID=%s", t.ctlMsg.GetServiceID(), t.GetSessionID())
+				continue
+			}
+			if err != nil {
+				logrus.Errorf("[%s] encrypt tunnel data %v", t.ctlMsg.GetServiceID(), err)
+				return
+			}
+		}
+		msg := t.newDataMsg(data)
+		msgs := []*message.ControlMessage{msg}
+		// TCP 隧道连接不需要分片
+		if t.reader == nil && t.hello.Load().HasCapability(message.CapFragment) {
+			t.fragID++
+			msgs = message.Fragment(msg, transport.ClampUDPMTU(t.Config.Transport, t.Config.UDPMTU), t.fragID)
+		}
+		for _, msg := range msgs {
+			t.sign(msg)
+			err = t.writeMsg(msg)
+			if err != nil {
+				logrus.Tracef("[%s] user to tunnel %v", t.ctlMsg.GetServiceID(), err)
+				return
+			}
 		}
 		t.ResetTimeout()
 	}
 }
+
+// newDataMsg 创建发送给服务端的隧道数据消息
+func (t *UDPTunnel) newDataMsg(data []byte) *message.ControlMessage {
+	msg := &message.ControlMessage{
+		Ctl:       message.NewTunnelData,
+		ServiceID: t.ctlMsg.GetServiceID(),
+		Payload: &message.ControlMessage_TunnelData{TunnelData: &message.TunnelData{
+			SessionID: t.GetSessionID(),
+			Data:      data,
+		}},
+	}
+	t.compat(msg)
+	return msg
+}
+
+func (t *UDPTunnel) sign(msg *message.ControlMessage) {
+	if t.auth != nil {
+		t.auth.Sign(msg)
+	}
+}
+
+// readMsg 读取隧道数据，TCP 隧道连接按长度分帧，共用连接时读取分发的数据
+func (t *UDPTunnel) readMsg() (*message.ControlMessage, error) {
+	if t.reader != nil {
+		return message.ReadTCP(t.reader)
+	}
+	if t.mux != nil {
+		select {
+		case <-t.ctx.Done():
+			return nil, t.ctx.Err()
+		case <-t.mux.done:
+			return nil, errors.New("udp mux closed")
+		case msg := <-t.muxCh:
+			return msg, nil
+		}
+	}
+	return message.ReadUDP(t.tunnelConn)
+}
+
+func (t *UDPTunnel) writeMsg(msg *message.ControlMessage) error {
+	if t.reader != nil {
+		return message.WriteTCP(msg, t.tunnelConn)
+	}
+	if t.mux != nil {
+		return t.mux.write(msg)
+	}
+	return message.WriteUDP(msg, t.tunnelConn, t.hello.Load().HasCapability(message.CapCompactData))
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"gnp/pkg/message"
+	"time"
+)
+
+const (
+	// UDPModeAuto 连接服务端后探测 UDP 是否可用，不可用时通过 TCP 隧道传输
+	UDPModeAuto = "auto"
+	// UDPModeUDP 总是使用 UDP 传输隧道数据
+	UDPModeUDP = "udp"
+	// UDPModeTCP 总是通过 TCP 隧道传输 UDP 数据
+	UDPModeTCP = "tcp"
+)
+
+const (
+	// udpProbeTimeout 每次 UDP 探测等待服务端响应的时间
+	udpProbeTimeout = time.Second
+	// udpProbeRetries UDP 探测次数，全部超时后认为 UDP 不可用
+	udpProbeRetries = 3
+)
+
+// initUDPMode 确定 UDP 隧道数据的传输方式，完成后新建的 UDP 隧道才能连接服务端
+func (c *Client) initUDPMode() {
+	defer close(c.udpModeReady)
+	if !c.hello.Load().HasCapability(message.CapUDPOverTCP) {
+		if c.Config.UDPMode == UDPModeTCP {
+			logrus.Warnf("server not supported udp over tcp, use udp")
+		}
+		return
+	}
+	switch c.Config.UDPMode {
+	case UDPModeTCP:
+		logrus.Infof("udp tunnel use tcp")
+		c.udpOverTCP.Store(true)
+	case UDPModeAuto:
+		err := c.probeUDP()
+		if err != nil {
+			logrus.Warnf("udp probe failed %v, udp tunnel use tcp", err)
+			c.udpOverTCP.Store(true)
+			return
+		}
+		logrus.Debugf("udp probe succeeded server=%s", c.serverAddr)
+	}
+}
+
+// probeUDP 向服务端 UDP 端口发送心跳，收到服务端响应说明 UDP 可用
+func (c *Client) probeUDP() error {
+	conn, err := c.dialer.DialPacket(c.serverAddr)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	msg := &message.ControlMessage{
+		Ctl:     message.KeepAlive,
+		Token:   c.Config.Token,
+		User:    c.Config.User,
+		Payload: &message.ControlMessage_Heartbeat{Heartbeat: &message.Heartbeat{SendTime: time.Now().UnixNano()}},
+	}
+	for i := 0; i < udpProbeRetries; i++ {
+		err = message.WriteUDP(msg, conn, false)
+		if err != nil {
+			return err
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(udpProbeTimeout))
+		for {
+			reply, err := message.ReadUDP(conn)
+			if err != nil {
+				break
+			}
+			if reply.GetCtl() == message.KeepAlive && c.auth(reply) {
+				return nil
+			}
+		}
+	}
+	return errors.New("no udp probe response")
+}
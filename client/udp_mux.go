@@ -0,0 +1,159 @@
+package client
+
+import (
+	"errors"
+	"github.com/sirupsen/logrus"
+	"gnp/pkg/message"
+	"net"
+	"sync"
+	"time"
+)
+
+// udpMuxQueueSize 每个会话等待处理的隧道数据包数量，超过后丢弃
+const udpMuxQueueSize = 128
+
+// udpMux 同一个代理服务的 UDP 会话共用一个连接服务端的 UDP 连接，按 SessionID 分发隧道数据
+type udpMux struct {
+	*Client
+	serviceID string
+	conn      net.Conn
+	// sessions 会话 ID 对应的隧道数据队列
+	sessions map[string]chan *message.ControlMessage
+	mx       sync.Mutex
+	done     chan struct{}
+	// onceClose 避免重复关闭引发异常
+	onceClose sync.Once
+}
+
+// getUDPMux 获取代理服务共用的 UDP 连接，不存在或者已关闭时新建
+func (c *Client) getUDPMux(serviceID string) (*udpMux, error) {
+	c.udpMuxMx.Lock()
+	defer c.udpMuxMx.Unlock()
+	if mux, ok := c.udpMuxes[serviceID]; ok {
+		return mux, nil
+	}
+	conn, err := c.dialer.DialPacket(c.serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	mux := &udpMux{
+		Client:    c,
+		serviceID: serviceID,
+		conn:      conn,
+		sessions:  make(map[string]chan *message.ControlMessage),
+		done:      make(chan struct{}),
+	}
+	c.udpMuxes[serviceID] = mux
+	go mux.read()
+	go mux.keepAlive()
+	logrus.Debugf("[%s] new udp mux local=%s", serviceID, conn.LocalAddr().String())
+	return mux, nil
+}
+
+// add 登记会话，返回会话的隧道数据队列
+func (m *udpMux) add(sessionID string) chan *message.ControlMessage {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	ch := make(chan *message.ControlMessage, udpMuxQueueSize)
+	m.sessions[sessionID] = ch
+	return ch
+}
+
+// remove 注销会话，只删除自己登记的队列，避免删除同一会话 ID 新登记的队列
+func (m *udpMux) remove(sessionID string, ch chan *message.ControlMessage) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	if m.sessions[sessionID] == ch {
+		delete(m.sessions, sessionID)
+	}
+}
+
+func (m *udpMux) sessionCount() int {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+	return len(m.sessions)
+}
+
+func (m *udpMux) write(msg *message.ControlMessage) error {
+	return message.WriteUDP(msg, m.conn, m.hello.Load().HasCapability(message.CapCompactData))
+}
+
+// read 读取服务端发送的隧道数据，分发给对应的会话，签名和分片由会话处理
+func (m *udpMux) read() {
+	defer m.Close()
+	for {
+		msg, err := message.ReadUDP(m.conn)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logrus.Errorf("[%s] udp mux read %v", m.serviceID, err)
+			}
+			return
+		}
+		switch msg.GetCtl() {
+		case message.KeepAlive:
+			if !m.auth(msg) {
+				logrus.Warnf("[%s] udp mux keep alive auth failed", m.serviceID)
+			}
+			continue
+		case message.NewTunnelData:
+		default:
+			logrus.Warnf("[%s] udp mux unknown ctl:=%d", m.serviceID, msg.GetCtl())
+			continue
+		}
+		m.mx.Lock()
+		ch, ok := m.sessions[msg.GetTunnelData().GetSessionID()]
+		m.mx.Unlock()
+		if !ok {
+			logrus.Debugf("[%s] udp mux session not found sessionID:=%s", m.serviceID, msg.GetTunnelData().GetSessionID())
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+			logrus.Debugf("[%s] udp mux session queue full sessionID:=%s", m.serviceID, msg.GetTunnelData().GetSessionID())
+		}
+	}
+}
+
+// keepAlive 定时发送心跳保持 NAT 映射，控制连接断开后没有会话时关闭
+func (m *udpMux) keepAlive() {
+	defer m.Close()
+	t := time.NewTicker(time.Second * time.Duration(m.Config.UDPMuxKeepAlivePeriod))
+	defer t.Stop()
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-m.tunnelCtx.Done():
+			return
+		case <-t.C:
+			if m.ctx.Err() != nil && m.sessionCount() == 0 {
+				return
+			}
+			err := m.write(&message.ControlMessage{
+				Ctl:     message.KeepAlive,
+				Token:   m.Config.Token,
+				User:    m.Config.User,
+				Payload: &message.ControlMessage_Heartbeat{Heartbeat: &message.Heartbeat{SendTime: time.Now().UnixNano()}},
+			})
+			if err != nil {
+				logrus.Errorf("[%s] udp mux keep alive %v", m.serviceID, err)
+				return
+			}
+		}
+	}
+}
+
+// Close 关闭共用连接，已登记的会话随之关闭，新会话重新建立连接
+func (m *udpMux) Close() {
+	m.onceClose.Do(func() {
+		m.udpMuxMx.Lock()
+		if m.udpMuxes[m.serviceID] == m {
+			delete(m.udpMuxes, m.serviceID)
+		}
+		m.udpMuxMx.Unlock()
+		close(m.done)
+		_ = m.conn.Close()
+		logrus.Debugf("[%s] close udp mux", m.serviceID)
+	})
+}
@@ -0,0 +1,245 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"github.com/sirupsen/logrus"
+	"gnp/pkg/config"
+	"gnp/pkg/message"
+	"gnp/pkg/util"
+	"net"
+	"sync"
+)
+
+// Visitor 访问者，在本地监听用户连接，使用预共享密钥加密后连接服务端代理端口
+// 服务端只转发密文，由注册代理服务的客户端解密后访问本地服务
+type Visitor struct {
+	ctx  context.Context
+	conf config.Visitor
+	// connTimeout 连接空闲超时时间
+	connTimeout int
+}
+
+func NewVisitor(ctx context.Context, conf config.Visitor, connTimeout int) *Visitor {
+	return &Visitor{
+		ctx:         ctx,
+		conf:        conf,
+		connTimeout: connTimeout,
+	}
+}
+
+func (v *Visitor) id() string {
+	return v.conf.Network + v.conf.BindAddr
+}
+
+func (v *Visitor) Start() {
+	logrus.Infof("[%s] visitor listen %s server=%s", v.id(), v.conf.BindAddr, v.conf.ServerAddr)
+	switch v.conf.Network {
+	case "tcp":
+		v.startTCP()
+	case "udp":
+		v.startUDP()
+	}
+}
+
+func (v *Visitor) startTCP() {
+	listener, err := net.Listen("tcp", v.conf.BindAddr)
+	if err != nil {
+		logrus.Errorf("[%s] visitor listen %v", v.id(), err)
+		return
+	}
+	go func() {
+		<-v.ctx.Done()
+		_ = listener.Close()
+	}()
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			logrus.Debugf("[%s] visitor accept %v", v.id(), err)
+			return
+		}
+		go v.handleTCP(conn)
+	}
+}
+
+func (v *Visitor) handleTCP(conn net.Conn) {
+	defer func() {
+		_ = conn.Close()
+	}()
+	serverConn, err := util.CreateDialTCP(v.conf.ServerAddr)
+	if err != nil {
+		logrus.Errorf("[%s] visitor connect server %v", v.id(), err)
+		return
+	}
+	defer func() {
+		_ = serverConn.Close()
+	}()
+	logrus.Debugf("[%s] visitor new conn user=%s", v.id(), conn.RemoteAddr().String())
+	tunnelConn := message.NewCipherConn(serverConn, v.conf.Key, false)
+	resetTimeout := func() {
+		_ = util.SetReadDeadline(conn)(v.connTimeout)
+		_ = util.SetReadDeadline(serverConn)(v.connTimeout)
+	}
+	resetTimeout()
+	go func() {
+		err := message.Copy(tunnelConn, conn, resetTimeout)
+		if err != nil {
+			logrus.Tracef("[%s] user to tunnel %v", v.id(), err)
+		}
+		_ = serverConn.Close()
+	}()
+	err = message.Copy(conn, tunnelConn, resetTimeout)
+	if errors.Is(err, message.ErrDecrypt) {
+		logrus.Warnf("[%s] tunnel to user %v", v.id(), err)
+		return
+	}
+	if err != nil {
+		logrus.Tracef("[%s] tunnel to user %v", v.id(), err)
+	}
+}
+
+// maxPendingPackets 加密握手完成前每个会话最多缓存的用户数据包
+const maxPendingPackets = 16
+
+// udpVisitorSession 用户地址对应的服务端连接，每个会话使用独立的会话密钥和防重放窗口
+type udpVisitorSession struct {
+	conn   *net.UDPConn
+	cipher *message.PacketCipher
+	mx     sync.Mutex
+	// pending 握手完成前收到的用户数据，握手完成后发送
+	pending [][]byte
+}
+
+// write 加密用户数据并发送，握手完成前缓存数据并重新发送握手请求
+func (s *udpVisitorSession) write(data []byte) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	if !s.cipher.Ready() {
+		if len(s.pending) < maxPendingPackets {
+			s.pending = append(s.pending, append([]byte(nil), data...))
+		}
+		hello, err := s.cipher.Hello()
+		if err != nil {
+			return err
+		}
+		_, err = s.conn.Write(hello)
+		return err
+	}
+	data, err := s.cipher.Seal(data)
+	if err != nil {
+		return err
+	}
+	_, err = s.conn.Write(data)
+	return err
+}
+
+// flush 握手完成后发送缓存的用户数据
+func (s *udpVisitorSession) flush() error {
+	s.mx.Lock()
+	pending := s.pending
+	s.pending = nil
+	s.mx.Unlock()
+	for _, data := range pending {
+		err := s.write(data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *Visitor) startUDP() {
+	addr, err := net.ResolveUDPAddr("udp", v.conf.BindAddr)
+	if err != nil {
+		logrus.Errorf("[%s] visitor listen %v", v.id(), err)
+		return
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		logrus.Errorf("[%s] visitor listen %v", v.id(), err)
+		return
+	}
+	go func() {
+		<-v.ctx.Done()
+		_ = conn.Close()
+	}()
+	// sessions 每个用户地址对应一个到服务端代理端口的 UDP 连接
+	var sessions sync.Map
+	// 加密后的数据不能超过服务端读取的长度
+	buf := make([]byte, message.MaxUDPDataSize-message.PacketCipherOverhead)
+	for {
+		n, userAddr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			logrus.Debugf("[%s] visitor read %v", v.id(), err)
+			return
+		}
+		var session *udpVisitorSession
+		if value, ok := sessions.Load(userAddr.String()); ok {
+			session = value.(*udpVisitorSession)
+		} else {
+			session, err = v.newUDPSession()
+			if err != nil {
+				logrus.Errorf("[%s] visitor connect server %v", v.id(), err)
+				continue
+			}
+			logrus.Debugf("[%s] visitor new session user=%s", v.id(), userAddr.String())
+			sessions.Store(userAddr.String(), session)
+			go func() {
+				defer func() {
+					sessions.Delete(userAddr.String())
+					_ = session.conn.Close()
+				}()
+				v.udpToUser(session, conn, userAddr)
+			}()
+		}
+		_ = util.SetReadDeadline(session.conn)(v.connTimeout)
+		err = session.write(buf[:n])
+		if err != nil {
+			logrus.Tracef("[%s] user to tunnel %v", v.id(), err)
+		}
+	}
+}
+
+func (v *Visitor) newUDPSession() (*udpVisitorSession, error) {
+	cipher, err := message.NewPacketCipher(v.conf.Key, false)
+	if err != nil {
+		return nil, err
+	}
+	serverConn, err := util.CreateDialUDP(v.conf.ServerAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &udpVisitorSession{conn: serverConn, cipher: cipher}, nil
+}
+
+// udpToUser 解密服务端代理端口返回的数据并发送给用户，空闲超时后结束会话
+func (v *Visitor) udpToUser(session *udpVisitorSession, conn *net.UDPConn, userAddr *net.UDPAddr) {
+	buf := make([]byte, message.MaxUDPDataSize)
+	for {
+		n, err := session.conn.Read(buf)
+		if err != nil {
+			logrus.Tracef("[%s] tunnel to user %v", v.id(), err)
+			return
+		}
+		data, _, err := session.cipher.Open(buf[:n])
+		if err != nil {
+			logrus.Warnf("[%s] visitor decrypt %v", v.id(), err)
+			continue
+		}
+		if data == nil {
+			// 握手完成
+			err = session.flush()
+			if err != nil {
+				logrus.Tracef("[%s] user to tunnel %v", v.id(), err)
+				return
+			}
+			continue
+		}
+		_, err = conn.WriteToUDP(data, userAddr)
+		if err != nil {
+			logrus.Tracef("[%s] tunnel to user %v", v.id(), err)
+			return
+		}
+		_ = util.SetReadDeadline(session.conn)(v.connTimeout)
+	}
+}
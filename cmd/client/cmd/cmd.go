@@ -4,7 +4,9 @@ import (
 	"context"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gnp"
 	"gnp/client"
+	"gnp/pkg/config"
 )
 
 var rootCtx context.Context
@@ -19,29 +21,27 @@ var rootCmd = &cobra.Command{
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		client.Run(rootCtx)
+		r := client.NewRunner(config.ClientConf)
+		watchConfig(cmd, r)
+		if err := r.Run(rootCtx); err != nil {
+			logrus.Fatalf("client exit: %v", err)
+		}
 	},
 	Example: "gnpc --services tcp,127.0.0.1:3389,6100 --services udp,127.0.0.1:3389,6100 -s localhost -p 6000",
 }
 
 var configFile string
 
-const (
-	logLevel           = 4
-	connTimout         = 3600
-	keepAlivePeriod    = 2
-	KeepAliveMaxFailed = 3
-	serverHost         = "localhost"
-	serverPort         = 6000
-)
+const logLevel = 4
 
 func init() {
 	// 初始化命令行参数
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "config file")
 	rootCmd.PersistentFlags().IntP("log-level", "l", logLevel, "log level")
 	rootCmd.PersistentFlags().BoolP("pprof-server", "", false, "enable pprof server")
-	rootCmd.Flags().StringP("server-host", "s", serverHost, "server bind address")
-	rootCmd.Flags().IntP("server-port", "p", serverPort, "server bind port")
+	defaultConf := gnp.DefaultClientConfig()
+	rootCmd.Flags().StringP("server-host", "s", defaultConf.ServerHost, "server bind address")
+	rootCmd.Flags().StringP("server-port", "p", defaultConf.ServerPort, "server bind port")
 	rootCmd.Flags().String("token", "", "token")
 	rootCmd.Flags().StringArray("services", nil, "services")
 }
@@ -2,12 +2,13 @@ package cmd
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
 	"github.com/sanmuyan/xpkg/xutil"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gnp"
+	"gnp/client"
 	"gnp/pkg/config"
 	"net/http"
 	_ "net/http/pprof"
@@ -30,10 +31,28 @@ func initConfig(cmd *cobra.Command) error {
 	})
 
 	viper.SetConfigName("config")
-	// 配置文件和命令行参数都不指定时的默认配置
-	viper.SetDefault("conn_timeout", connTimout)
-	viper.SetDefault("keep_alive_period", keepAlivePeriod)
-	viper.SetDefault("keep_alive_max_failed", KeepAliveMaxFailed)
+	// 配置文件和命令行参数都不指定时的默认配置，和嵌入使用的默认配置一致
+	defaultConf := gnp.DefaultClientConfig()
+	viper.SetDefault("conn_timeout", defaultConf.ConnTimeout)
+	viper.SetDefault("udp_conn_timeout", defaultConf.UDPConnTimeout)
+	viper.SetDefault("keep_alive_period", defaultConf.KeepAlivePeriod)
+	viper.SetDefault("keep_alive_max_failed", defaultConf.KeepAliveMaxFailed)
+	viper.SetDefault("reconnect_interval", defaultConf.ReconnectInterval)
+	viper.SetDefault("reconnect_max_interval", defaultConf.ReconnectMaxInterval)
+	viper.SetDefault("server_strategy", defaultConf.ServerStrategy)
+	viper.SetDefault("primary_check_interval", defaultConf.PrimaryCheckInterval)
+	viper.SetDefault("udp_mtu", defaultConf.UDPMTU)
+	viper.SetDefault("udp_fragment_timeout", defaultConf.UDPFragmentTimeout)
+	viper.SetDefault("udp_mode", defaultConf.UDPMode)
+	viper.SetDefault("udp_mux_keep_alive_period", defaultConf.UDPMuxKeepAlivePeriod)
+	viper.SetDefault("transport", defaultConf.Transport)
+	viper.SetDefault("kcp.nodelay", defaultConf.KCP.NoDelay)
+	viper.SetDefault("kcp.interval", defaultConf.KCP.Interval)
+	viper.SetDefault("kcp.resend", defaultConf.KCP.Resend)
+	viper.SetDefault("kcp.no_congestion", defaultConf.KCP.NoCongestion)
+	viper.SetDefault("kcp.snd_wnd", defaultConf.KCP.SndWnd)
+	viper.SetDefault("kcp.rcv_wnd", defaultConf.KCP.RcvWnd)
+	viper.SetDefault("kcp.mtu", defaultConf.KCP.MTU)
 
 	// 设置默认配置文件
 	if len(configFile) == 0 {
@@ -65,26 +84,11 @@ func initConfig(cmd *cobra.Command) error {
 	_ = viper.BindPFlag("server_port", cmd.Flags().Lookup("server-port"))
 	_ = viper.BindPFlag("token", cmd.Flags().Lookup("token"))
 
-	err := viper.Unmarshal(&config.ClientConf)
+	conf, err := loadConfig(cmd)
 	if err != nil {
 		return err
 	}
-
-	services, err := cmd.Flags().GetStringArray("services")
-	if err != nil {
-		return err
-	}
-
-	for _, service := range services {
-		parts := strings.Split(service, ",")
-		if len(parts) == 3 {
-			config.ClientConf.Services = append(config.ClientConf.Services, config.Service{
-				Network:   parts[0],
-				LocalAddr: parts[1],
-				ProxyPort: parts[2],
-			})
-		}
-	}
+	config.ClientConf = conf
 
 	logrus.SetLevel(logrus.Level(config.ClientConf.LogLevel))
 	if logrus.Level(config.ClientConf.LogLevel) >= logrus.DebugLevel {
@@ -99,19 +103,35 @@ func initConfig(cmd *cobra.Command) error {
 		go pprofServer(7777)
 	}
 
-	if len(config.ClientConf.ServerHost) == 0 {
-		return errors.New("server host is empty")
+	logrus.Debugf("config init completed: %+v", string(xutil.RemoveError(json.Marshal(config.ClientConf))))
+	return nil
+}
+
+// loadConfig 从配置文件和命令行参数加载客户端配置
+func loadConfig(cmd *cobra.Command) (config.ClientConfig, error) {
+	var conf config.ClientConfig
+	err := viper.Unmarshal(&conf)
+	if err != nil {
+		return conf, err
 	}
 
-	if len(config.ClientConf.ServerPort) == 0 {
-		return errors.New("server port is empty")
+	services, err := cmd.Flags().GetStringArray("services")
+	if err != nil {
+		return conf, err
 	}
 
-	if len(config.ClientConf.Services) == 0 {
-		return errors.New("services is empty")
+	for _, service := range services {
+		parts := strings.Split(service, ",")
+		if len(parts) == 3 {
+			conf.Services = append(conf.Services, config.Service{
+				Network:   parts[0],
+				LocalAddr: parts[1],
+				ProxyPort: parts[2],
+			})
+		}
 	}
-	logrus.Debugf("config init completed: %+v", string(xutil.RemoveError(json.Marshal(config.ClientConf))))
-	return nil
+
+	return conf, client.CheckConfig(&conf)
 }
 
 func pprofServer(port int) {
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"gnp/client"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadDelay 配置文件变化后延迟加载，避免读取到写入一半的配置文件导致服务被误注销
+const reloadDelay = time.Millisecond * 500
+
+var (
+	reloadMx    sync.Mutex
+	timerMx     sync.Mutex
+	reloadTimer *time.Timer
+)
+
+// watchConfig 监听配置文件变化和 SIGHUP 信号，重新加载客户端配置
+func watchConfig(cmd *cobra.Command, r *client.Runner) {
+	if len(configFile) == 0 {
+		return
+	}
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		logrus.Infof("config file changed %s", e.Name)
+		timerMx.Lock()
+		defer timerMx.Unlock()
+		if reloadTimer != nil {
+			reloadTimer.Stop()
+		}
+		reloadTimer = time.AfterFunc(reloadDelay, func() {
+			if err := viper.ReadInConfig(); err != nil {
+				logrus.Errorf("read config %v", err)
+				return
+			}
+			reloadConfig(cmd, r)
+		})
+	})
+	viper.WatchConfig()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			logrus.Info("received SIGHUP, reload config")
+			if err := viper.ReadInConfig(); err != nil {
+				logrus.Errorf("read config %v", err)
+				continue
+			}
+			reloadConfig(cmd, r)
+		}
+	}()
+}
+
+func reloadConfig(cmd *cobra.Command, r *client.Runner) {
+	reloadMx.Lock()
+	defer reloadMx.Unlock()
+	conf, err := loadConfig(cmd)
+	if err != nil {
+		logrus.Errorf("reload config %v", err)
+		return
+	}
+	r.Reload(conf)
+}
@@ -2,8 +2,11 @@ package cmd
 
 import (
 	"context"
+	"expvar"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"gnp"
+	"gnp/pkg/config"
 	"gnp/server"
 )
 
@@ -19,28 +22,35 @@ var rootCmd = &cobra.Command{
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		server.Run(rootCtx)
+		s, err := server.NewServer(config.ServerConf)
+		if err != nil {
+			logrus.Fatalf("new server %v", err)
+		}
+		expvar.Publish("udp_queue_drops", s.UDPDropVars())
+		watchConfig(s)
+		err = s.Start()
+		if err != nil {
+			logrus.Fatalf("server listen %v", err)
+		}
+		<-rootCtx.Done()
+		_ = s.Shutdown(context.Background())
 	},
 }
 
 var configFile string
 
-const (
-	logLevel    = 4
-	serverBind  = "0.0.0.0"
-	serverPort  = 6000
-	allowPorts  = "1-65535"
-	connTimeout = 3600
-)
+const logLevel = 4
 
 func init() {
 	// 初始化命令行参数
 	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "config file")
 	rootCmd.PersistentFlags().IntP("log-level", "l", logLevel, "log level")
-	rootCmd.Flags().StringP("server-bind", "s", serverBind, "server bind addr")
-	rootCmd.Flags().IntP("server-port", "p", serverPort, "server bind port")
+	rootCmd.PersistentFlags().BoolP("pprof-server", "", false, "enable pprof server")
+	defaultConf := gnp.DefaultServerConfig()
+	rootCmd.Flags().StringP("server-bind", "s", defaultConf.ServerBind, "server bind addr")
+	rootCmd.Flags().StringP("server-port", "p", defaultConf.ServerPort, "server bind port")
 	rootCmd.Flags().String("token", "", "token")
-	rootCmd.Flags().String("allow-ports", allowPorts, "allow ports")
+	rootCmd.Flags().String("allow-ports", defaultConf.AllowPorts, "allow ports")
 }
 
 func Execute(ctx context.Context) {
@@ -7,7 +7,9 @@ import (
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
+	"gnp"
 	"gnp/pkg/config"
+	"gnp/server"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
@@ -28,8 +30,26 @@ func initConfig(cmd *cobra.Command) error {
 	})
 
 	viper.SetConfigName("config")
-	// 配置文件和命令行参数都不指定时的默认配置
-	viper.SetDefault("conn_timeout", connTimeout)
+	// 配置文件和命令行参数都不指定时的默认配置，和嵌入使用的默认配置一致
+	defaultConf := gnp.DefaultServerConfig()
+	viper.SetDefault("conn_timeout", defaultConf.ConnTimeout)
+	viper.SetDefault("udp_conn_timeout", defaultConf.UDPConnTimeout)
+	viper.SetDefault("shutdown_timeout", defaultConf.ShutdownTimeout)
+	viper.SetDefault("resume_timeout", defaultConf.ResumeTimeout)
+	viper.SetDefault("udp_mtu", defaultConf.UDPMTU)
+	viper.SetDefault("udp_fragment_timeout", defaultConf.UDPFragmentTimeout)
+	viper.SetDefault("udp_queue_size", defaultConf.UDPQueueSize)
+	viper.SetDefault("udp_queue_policy", defaultConf.UDPQueuePolicy)
+	viper.SetDefault("transport", defaultConf.Transport)
+	viper.SetDefault("auth.type", defaultConf.Auth.Type)
+	viper.SetDefault("auth.webhook_timeout", defaultConf.Auth.WebhookTimeout)
+	viper.SetDefault("kcp.nodelay", defaultConf.KCP.NoDelay)
+	viper.SetDefault("kcp.interval", defaultConf.KCP.Interval)
+	viper.SetDefault("kcp.resend", defaultConf.KCP.Resend)
+	viper.SetDefault("kcp.no_congestion", defaultConf.KCP.NoCongestion)
+	viper.SetDefault("kcp.snd_wnd", defaultConf.KCP.SndWnd)
+	viper.SetDefault("kcp.rcv_wnd", defaultConf.KCP.RcvWnd)
+	viper.SetDefault("kcp.mtu", defaultConf.KCP.MTU)
 
 	// 设置默认配置文件
 	if len(configFile) == 0 {
@@ -56,15 +76,18 @@ func initConfig(cmd *cobra.Command) error {
 	// 绑定命令行参数到配置项
 	// 配置项优先级：命令行参数 > 配置文件 > 默认命令行参数
 	_ = viper.BindPFlag("log_level", cmd.Flags().Lookup("log-level"))
+	_ = viper.BindPFlag("pprof-server", cmd.Flags().Lookup("pprof-server"))
 	_ = viper.BindPFlag("server_bind", cmd.Flags().Lookup("server-bind"))
 	_ = viper.BindPFlag("server_port", cmd.Flags().Lookup("server-port"))
 	_ = viper.BindPFlag("token", cmd.Flags().Lookup("token"))
 	_ = viper.BindPFlag("allow_ports", cmd.Flags().Lookup("allow-ports"))
 
-	err := viper.Unmarshal(&config.ServerConf)
+	conf, err := loadConfig()
 	if err != nil {
 		return err
 	}
+	config.ServerConf = conf
+
 	logrus.SetLevel(logrus.Level(config.ServerConf.LogLevel))
 	if logrus.Level(config.ServerConf.LogLevel) >= logrus.DebugLevel {
 		logrus.SetReportCaller(true)
@@ -82,6 +105,16 @@ func initConfig(cmd *cobra.Command) error {
 	return nil
 }
 
+// loadConfig 从配置文件和命令行参数加载服务端配置
+func loadConfig() (config.ServerConfig, error) {
+	var conf config.ServerConfig
+	err := viper.Unmarshal(&conf)
+	if err != nil {
+		return conf, err
+	}
+	return conf, server.CheckConfig(&conf)
+}
+
 func pprofServer(port int) {
 	logrus.Infof("pprof server listening on 0.0.0.0:%d", port)
 	err := http.ListenAndServe("0.0.0.0:"+fmt.Sprintf("%d", port), nil)
@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+	"gnp/server"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// reloadDelay 配置文件变化后延迟加载，避免读取到写入一半的配置文件导致服务被误注销
+const reloadDelay = time.Millisecond * 500
+
+var (
+	reloadMx    sync.Mutex
+	reloadTimer *time.Timer
+)
+
+// watchConfig 监听配置文件变化和 SIGHUP 信号，重新加载服务端配置
+func watchConfig(s *server.Server) {
+	if len(configFile) == 0 {
+		return
+	}
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		logrus.Infof("config file changed %s", e.Name)
+		reloadMx.Lock()
+		defer reloadMx.Unlock()
+		if reloadTimer != nil {
+			reloadTimer.Stop()
+		}
+		reloadTimer = time.AfterFunc(reloadDelay, func() {
+			if err := viper.ReadInConfig(); err != nil {
+				logrus.Errorf("read config %v", err)
+				return
+			}
+			reloadConfig(s)
+		})
+	})
+	viper.WatchConfig()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP)
+	go func() {
+		for range sigs {
+			logrus.Info("received SIGHUP, reload config")
+			if err := viper.ReadInConfig(); err != nil {
+				logrus.Errorf("read config %v", err)
+				continue
+			}
+			reloadConfig(s)
+		}
+	}()
+}
+
+// reloadConfig 加载最新配置并通知服务端
+func reloadConfig(s *server.Server) {
+	conf, err := loadConfig()
+	if err != nil {
+		logrus.Errorf("reload config %v", err)
+		return
+	}
+	logrus.SetLevel(logrus.Level(conf.LogLevel))
+	s.Reload(conf)
+}
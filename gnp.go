@@ -0,0 +1,46 @@
+// Package gnp 在其他 Go 程序中嵌入服务端和客户端，配置通过参数传入，不读取命令行和全局配置，
+// 出错时返回错误而不是退出进程，同一进程可以运行多个互相独立的实例
+package gnp
+
+import (
+	"gnp/pkg/auth"
+	"gnp/pkg/config"
+	"gnp/pkg/event"
+)
+
+type (
+	ServerConfig = config.ServerConfig
+	ClientConfig = config.ClientConfig
+	Service      = config.Service
+	Visitor      = config.Visitor
+	Event        = event.Event
+	EventType    = event.Type
+	// EventHandler 事件回调，在产生事件的协程中同步调用，不能阻塞
+	EventHandler = event.Handler
+	// Authenticator 自定义服务端鉴权方式，拒绝时返回的错误作为原因发送给客户端
+	Authenticator = auth.Authenticator
+	AuthRequest   = auth.Request
+)
+
+const (
+	EventConnected         = event.Connected
+	EventDisconnected      = event.Disconnected
+	EventServiceRegistered = event.ServiceRegistered
+	EventServiceRejected   = event.ServiceRejected
+	EventServiceClosed     = event.ServiceClosed
+	EventSessionOpened     = event.SessionOpened
+	EventSessionClosed     = event.SessionClosed
+)
+
+// defaultKCP 默认 KCP 参数，使用快速模式
+func defaultKCP() config.KCP {
+	return config.KCP{
+		NoDelay:      1,
+		Interval:     10,
+		Resend:       2,
+		NoCongestion: 1,
+		SndWnd:       1024,
+		RcvWnd:       1024,
+		MTU:          1350,
+	}
+}
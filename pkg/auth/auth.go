@@ -0,0 +1,85 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"gnp/pkg/config"
+	"time"
+)
+
+const (
+	// Login 客户端建立控制连接
+	Login = "login"
+	// Register 客户端注册代理服务
+	Register = "register"
+)
+
+const (
+	TypeToken     = "token"
+	TypeTokenFile = "token_file"
+	TypeHtpasswd  = "htpasswd"
+	TypeWebhook   = "webhook"
+)
+
+// ErrDenied 用户名或者 token 错误
+var ErrDenied = errors.New("invalid user or token")
+
+// Request 鉴权请求，登录时代理服务相关字段为空
+type Request struct {
+	Action string `json:"action"`
+	// Addr 客户端地址
+	Addr string `json:"addr"`
+	// User 客户端声明的用户名，可以为空
+	User     string `json:"user"`
+	Token    string `json:"token"`
+	ClientID string `json:"client_id"`
+	// ServiceID 注册的代理服务 ID
+	ServiceID string `json:"service_id,omitempty"`
+	Network   string `json:"network,omitempty"`
+	ProxyPort string `json:"proxy_port,omitempty"`
+}
+
+// Authenticator 鉴权客户端登录和代理服务注册
+type Authenticator interface {
+	// Authenticate 鉴权通过时返回空，返回的错误作为拒绝原因发送给客户端
+	Authenticate(ctx context.Context, req *Request) error
+}
+
+// New 按配置创建鉴权方式，token 为服务端 token 配置
+func New(conf *config.Auth, token string) (Authenticator, error) {
+	switch conf.Type {
+	case "", TypeToken:
+		return NewToken(token), nil
+	case TypeTokenFile:
+		return NewTokenFile(conf.File)
+	case TypeHtpasswd:
+		return NewHtpasswd(conf.File)
+	case TypeWebhook:
+		return NewWebhook(conf.WebhookURL, time.Second*time.Duration(conf.WebhookTimeout))
+	default:
+		return nil, fmt.Errorf("unsupported auth type %s", conf.Type)
+	}
+}
+
+// Token 所有客户端共用一个 token，不区分用户
+type Token struct {
+	token string
+}
+
+func NewToken(token string) *Token {
+	return &Token{token: token}
+}
+
+func (t *Token) Authenticate(_ context.Context, req *Request) error {
+	if !equal(t.token, req.Token) {
+		return ErrDenied
+	}
+	return nil
+}
+
+// equal 固定时间比较，避免通过响应时间猜测 token
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
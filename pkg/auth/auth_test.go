@@ -0,0 +1,34 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"gnp/pkg/config"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	a, err := New(&config.Auth{}, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		token   string
+		wantErr error
+	}{
+		{"valid", "abc", nil},
+		{"invalid", "abd", ErrDenied},
+		{"empty", "", ErrDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.Authenticate(context.Background(), &Request{Action: Login, Token: tt.token}); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if _, err := New(&config.Auth{Type: "ldap"}, "abc"); err == nil {
+		t.Fatal("New() unsupported type error = nil")
+	}
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sanmuyan/xpkg/xnet"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// readUsers 读取用户文件，每行用冒号分隔字段，第一个字段为用户名，忽略空行和 # 开头的注释
+func readUsers(file string, minFields, maxFields int) (map[string][]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	users := make(map[string][]string)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, ":", maxFields)
+		if len(fields) < minFields || fields[0] == "" {
+			return nil, fmt.Errorf("%s:%d invalid user", file, line)
+		}
+		if _, ok := users[fields[0]]; ok {
+			return nil, fmt.Errorf("%s:%d duplicate user %s", file, line, fields[0])
+		}
+		users[fields[0]] = fields[1:]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("%s no users", file)
+	}
+	return users, nil
+}
+
+// TokenFile 多用户 token 文件，每行 user:token 或者 user:token:allow_ports，
+// 设置 allow_ports 时用户只能注册范围内的代理端口，修改后重载配置生效
+type TokenFile struct {
+	users map[string][]string
+}
+
+func NewTokenFile(file string) (*TokenFile, error) {
+	users, err := readUsers(file, 2, 3)
+	if err != nil {
+		return nil, err
+	}
+	return &TokenFile{users: users}, nil
+}
+
+func (t *TokenFile) Authenticate(_ context.Context, req *Request) error {
+	fields, ok := t.users[req.User]
+	if !ok || !equal(fields[0], req.Token) {
+		return ErrDenied
+	}
+	if req.Action == Register && len(fields) > 1 && fields[1] != "" && !xnet.IsAllowPort(fields[1], req.ProxyPort) {
+		return fmt.Errorf("port %s is not allowed for user %s", req.ProxyPort, req.User)
+	}
+	return nil
+}
+
+// Htpasswd htpasswd 格式的用户文件，每行 user:bcrypt，只支持 bcrypt，
+// 可以使用 htpasswd -nbB user token 生成，修改后重载配置生效
+type Htpasswd struct {
+	users map[string][]string
+	// verified 校验通过的 token，bcrypt 比较耗时，同一个 token 不重复校验
+	verified sync.Map
+}
+
+func NewHtpasswd(file string) (*Htpasswd, error) {
+	users, err := readUsers(file, 2, 2)
+	if err != nil {
+		return nil, err
+	}
+	for user, fields := range users {
+		if _, err := bcrypt.Cost([]byte(fields[0])); err != nil {
+			return nil, fmt.Errorf("%s user %s is not bcrypt %v", file, user, err)
+		}
+	}
+	return &Htpasswd{users: users}, nil
+}
+
+func (h *Htpasswd) Authenticate(_ context.Context, req *Request) error {
+	fields, ok := h.users[req.User]
+	if !ok {
+		return ErrDenied
+	}
+	if token, ok := h.verified.Load(req.User); ok && equal(token.(string), req.Token) {
+		return nil
+	}
+	err := bcrypt.CompareHashAndPassword([]byte(fields[0]), []byte(req.Token))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrDenied
+		}
+		return err
+	}
+	h.verified.Store(req.User, req.Token)
+	return nil
+}
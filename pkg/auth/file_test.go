@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFile(t *testing.T, content string) string {
+	t.Helper()
+	file := filepath.Join(t.TempDir(), "users")
+	err := os.WriteFile(file, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestReadUsers(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   string
+		maxFields int
+		want      map[string][]string
+		wantErr   bool
+	}{
+		{"users", "alice:t1\nbob:t2:16100-16120\n", 3, map[string][]string{"alice": {"t1"}, "bob": {"t2", "16100-16120"}}, false},
+		{"comments and blank lines", "# users\n\n  alice:t1  \n", 3, map[string][]string{"alice": {"t1"}}, false},
+		{"token contains colon", "alice:a:b:c\n", 2, map[string][]string{"alice": {"a:b:c"}}, false},
+		{"empty token", "alice:\n", 3, map[string][]string{"alice": {""}}, false},
+		{"missing token", "alice\n", 3, nil, true},
+		{"empty user", ":t1\n", 3, nil, true},
+		{"duplicate user", "alice:t1\nalice:t2\n", 3, nil, true},
+		{"no users", "# users\n", 3, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readUsers(writeFile(t, tt.content), 2, tt.maxFields)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("readUsers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("readUsers() = %v, want %v", got, tt.want)
+			}
+			for user, fields := range tt.want {
+				if len(got[user]) != len(fields) {
+					t.Fatalf("readUsers() %s = %v, want %v", user, got[user], fields)
+				}
+				for i := range fields {
+					if got[user][i] != fields[i] {
+						t.Fatalf("readUsers() %s = %v, want %v", user, got[user], fields)
+					}
+				}
+			}
+		})
+	}
+	t.Run("missing file", func(t *testing.T) {
+		if _, err := readUsers(filepath.Join(t.TempDir(), "users"), 2, 3); err == nil {
+			t.Fatal("readUsers() error = nil, want error")
+		}
+	})
+}
+
+func TestTokenFile(t *testing.T) {
+	a, err := NewTokenFile(writeFile(t, "alice:t1:16100-16120\nbob:t2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		req     Request
+		wantErr bool
+	}{
+		{"login", Request{Action: Login, User: "alice", Token: "t1"}, false},
+		{"wrong token", Request{Action: Login, User: "alice", Token: "t2"}, true},
+		{"unknown user", Request{Action: Login, User: "carol", Token: "t1"}, true},
+		{"empty user", Request{Action: Login, Token: "t1"}, true},
+		{"allowed port", Request{Action: Register, User: "alice", Token: "t1", ProxyPort: "16110"}, false},
+		{"not allowed port", Request{Action: Register, User: "alice", Token: "t1", ProxyPort: "16190"}, true},
+		{"any port", Request{Action: Register, User: "bob", Token: "t2", ProxyPort: "16190"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.Authenticate(context.Background(), &tt.req); (err != nil) != tt.wantErr {
+				t.Fatalf("Authenticate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHtpasswd(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("t1"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Run("not bcrypt", func(t *testing.T) {
+		if _, err := NewHtpasswd(writeFile(t, "alice:{SHA}abc\n")); err == nil {
+			t.Fatal("NewHtpasswd() error = nil, want error")
+		}
+	})
+	a, err := NewHtpasswd(writeFile(t, "alice:"+string(hash)+"\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 按顺序执行，校验通过的 token 会被缓存
+	tests := []struct {
+		name    string
+		req     Request
+		wantErr error
+	}{
+		{"login", Request{Action: Login, User: "alice", Token: "t1"}, nil},
+		{"cached", Request{Action: Register, User: "alice", Token: "t1"}, nil},
+		{"wrong token after cached", Request{Action: Login, User: "alice", Token: "t2"}, ErrDenied},
+		{"unknown user", Request{Action: Login, User: "bob", Token: "t1"}, ErrDenied},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := a.Authenticate(context.Background(), &tt.req); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// webhookResponse 鉴权服务的响应，allow 为 false 时 reason 作为拒绝原因
+type webhookResponse struct {
+	Allow  bool   `json:"allow"`
+	Reason string `json:"reason"`
+}
+
+// Webhook 把鉴权请求以 JSON 格式 POST 到本地鉴权服务，鉴权服务返回 200 和 {"allow": true} 时通过
+type Webhook struct {
+	url    string
+	client *http.Client
+}
+
+func NewWebhook(url string, timeout time.Duration) (*Webhook, error) {
+	if url == "" {
+		return nil, errors.New("auth webhook url is empty")
+	}
+	if timeout <= 0 {
+		return nil, errors.New("auth webhook timeout must be greater than 0")
+	}
+	return &Webhook{
+		url:    url,
+		client: &http.Client{Timeout: timeout},
+	}, nil
+}
+
+func (w *Webhook) Authenticate(ctx context.Context, req *Request) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("auth webhook %v", err)
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("auth webhook status %d", resp.StatusCode)
+	}
+	var res webhookResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&res)
+	if err != nil {
+		return fmt.Errorf("auth webhook response %v", err)
+	}
+	if !res.Allow {
+		if res.Reason == "" {
+			return ErrDenied
+		}
+		return errors.New(res.Reason)
+	}
+	return nil
+}
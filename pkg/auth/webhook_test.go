@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		url     string
+		timeout time.Duration
+		wantErr bool
+	}{
+		{"valid", "http://127.0.0.1/auth", time.Second, false},
+		{"empty url", "", time.Second, true},
+		{"zero timeout", "http://127.0.0.1/auth", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewWebhook(tt.url, tt.timeout); (err != nil) != tt.wantErr {
+				t.Fatalf("NewWebhook() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		// wantErr 错误信息包含的内容，为空时鉴权通过
+		wantErr string
+	}{
+		{"allow", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"allow": true}`))
+		}, ""},
+		{"deny", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"allow": false}`))
+		}, ErrDenied.Error()},
+		{"deny with reason", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"allow": false, "reason": "user disabled"}`))
+		}, "user disabled"},
+		{"status", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, "auth webhook status 500"},
+		{"invalid response", func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`allow`))
+		}, "auth webhook response invalid character 'a' looking for beginning of value"},
+		{"timeout", func(w http.ResponseWriter, r *http.Request) {
+			time.Sleep(200 * time.Millisecond)
+		}, "Client.Timeout exceeded"},
+	}
+	req := &Request{Action: Register, Addr: "127.0.0.1:50000", User: "alice", Token: "t1", ClientID: "c1", ServiceID: "tcp16100", Network: "tcp", ProxyPort: "16100"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reqCh := make(chan Request, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				var got Request
+				if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				reqCh <- got
+				tt.handler(w, r)
+			}))
+			defer server.Close()
+			a, err := NewWebhook(server.URL, 100*time.Millisecond)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = a.Authenticate(context.Background(), req)
+			if (err == nil) != (tt.wantErr == "") || err != nil && !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Authenticate() error = %v, want %q", err, tt.wantErr)
+			}
+			if got := <-reqCh; got != *req {
+				t.Fatalf("webhook request = %+v, want %+v", got, *req)
+			}
+		})
+	}
+}
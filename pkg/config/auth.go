@@ -0,0 +1,13 @@
+package config
+
+// Auth 服务端鉴权客户端的方式
+type Auth struct {
+	// Type 鉴权方式 token、token_file、htpasswd 或 webhook，token 使用 token 配置
+	Type string `mapstructure:"type"`
+	// File token_file 和 htpasswd 的用户文件
+	File string `mapstructure:"file"`
+	// WebhookURL webhook 鉴权服务地址
+	WebhookURL string `mapstructure:"webhook_url"`
+	// WebhookTimeout webhook 请求超时时间，单位秒
+	WebhookTimeout int `mapstructure:"webhook_timeout"`
+}
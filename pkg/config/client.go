@@ -4,17 +4,70 @@ type Service struct {
 	ProxyPort string `mapstructure:"proxy_port"`
 	LocalAddr string `mapstructure:"local_addr"`
 	Network   string `mapstructure:"network"`
+	// Compression TCP 隧道数据压缩算法 snappy 或 zstd，为空表示不压缩
+	Compression string `mapstructure:"compression"`
+	// Key 端到端加密的预共享密钥，访问者使用相同的密钥，服务端只转发密文
+	Key string `mapstructure:"key"`
+	// UDPQueueSize UDP 服务每个队列的长度，为 0 时使用服务端配置
+	UDPQueueSize int `mapstructure:"udp_queue_size"`
+	// UDPQueuePolicy UDP 服务队列满时的处理策略 drop-newest、drop-oldest 或 block，为空时使用服务端配置
+	UDPQueuePolicy string `mapstructure:"udp_queue_policy"`
+	// ConnTimeout 会话空闲超时时间，单位秒，为 0 时按服务类型使用 conn_timeout 或 udp_conn_timeout
+	ConnTimeout int `mapstructure:"conn_timeout"`
+}
+
+// Visitor 访问者，在本地监听用户连接，加密后连接服务端代理端口
+type Visitor struct {
+	Network  string `mapstructure:"network"`
+	BindAddr string `mapstructure:"bind_addr"`
+	// ServerAddr 服务端代理端口地址
+	ServerAddr string `mapstructure:"server_addr"`
+	Key        string `mapstructure:"key"`
+}
+
+type Server struct {
+	Host   string `mapstructure:"host"`
+	Port   string `mapstructure:"port"`
+	Weight int    `mapstructure:"weight"`
 }
 
 type ClientConfig struct {
-	LogLevel           int       `mapstructure:"log_level"`
-	ServerHost         string    `mapstructure:"server_host"`
-	ServerPort         string    `mapstructure:"server_port"`
-	Services           []Service `mapstructure:"services"`
-	Token              string    `mapstructure:"token"`
-	KeepAlivePeriod    int       `mapstructure:"keep_alive_period"`
-	KeepAliveMaxFailed int       `mapstructure:"keep_alive_max_failed"`
-	ConnTimeout        int       `mapstructure:"conn_timeout"`
+	LogLevel             int       `mapstructure:"log_level"`
+	ServerHost           string    `mapstructure:"server_host"`
+	ServerPort           string    `mapstructure:"server_port"`
+	Servers              []Server  `mapstructure:"servers"`
+	ServerStrategy       string    `mapstructure:"server_strategy"`
+	Transport            string    `mapstructure:"transport"`
+	WebsocketPath        string    `mapstructure:"websocket_path"`
+	TLS                  bool      `mapstructure:"tls"`
+	TLSSkipVerify        bool      `mapstructure:"tls_skip_verify"`
+	KCP                  KCP       `mapstructure:"kcp"`
+	Proxy                Proxy     `mapstructure:"proxy"`
+	PreferPrimary        bool      `mapstructure:"prefer_primary"`
+	PrimaryCheckInterval int       `mapstructure:"primary_check_interval"`
+	Services             []Service `mapstructure:"services"`
+	Visitors             []Visitor `mapstructure:"visitors"`
+	Token                string    `mapstructure:"token"`
+	KeepAlivePeriod      int       `mapstructure:"keep_alive_period"`
+	KeepAliveMaxFailed   int       `mapstructure:"keep_alive_max_failed"`
+	ConnTimeout          int       `mapstructure:"conn_timeout"`
+	ReconnectInterval    int       `mapstructure:"reconnect_interval"`
+	ReconnectMaxInterval int       `mapstructure:"reconnect_max_interval"`
+	ReconnectMaxRetries  int       `mapstructure:"reconnect_max_retries"`
+	// UDPConnTimeout UDP 会话的空闲超时时间，ConnTimeout 只用于 TCP 会话
+	UDPConnTimeout int `mapstructure:"udp_conn_timeout"`
+	// UDPMTU UDP 隧道数据包的最大长度，超过后分片发送，QUIC 传输时不超过数据报能承载的长度
+	UDPMTU int `mapstructure:"udp_mtu"`
+	// UDPFragmentTimeout 分片重组超时时间，单位秒
+	UDPFragmentTimeout int `mapstructure:"udp_fragment_timeout"`
+	// UDPMode UDP 隧道数据的传输方式 auto、udp 或 tcp，auto 在 UDP 探测失败时通过 TCP 隧道传输
+	UDPMode string `mapstructure:"udp_mode"`
+	// UDPMux 同一个代理服务的 UDP 会话共用一个连接服务端的 UDP 连接
+	UDPMux bool `mapstructure:"udp_mux"`
+	// UDPMuxKeepAlivePeriod 共用 UDP 连接的心跳间隔，保持 NAT 映射，单位秒
+	UDPMuxKeepAlivePeriod int `mapstructure:"udp_mux_keep_alive_period"`
+	// User 用户名，服务端使用 token_file、htpasswd 或 webhook 鉴权时和 Token 一起校验
+	User string `mapstructure:"user"`
 }
 
 var ClientConf ClientConfig
@@ -0,0 +1,12 @@
+package config
+
+// KCP KCP 传输参数
+type KCP struct {
+	NoDelay      int `mapstructure:"nodelay"`
+	Interval     int `mapstructure:"interval"`
+	Resend       int `mapstructure:"resend"`
+	NoCongestion int `mapstructure:"no_congestion"`
+	SndWnd       int `mapstructure:"snd_wnd"`
+	RcvWnd       int `mapstructure:"rcv_wnd"`
+	MTU          int `mapstructure:"mtu"`
+}
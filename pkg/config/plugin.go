@@ -0,0 +1,14 @@
+package config
+
+// Plugin 服务端插件，客户端登录、注册代理服务和新建用户会话前请求插件服务
+type Plugin struct {
+	Name string `mapstructure:"name"`
+	// URL 插件服务地址，请求使用 POST JSON
+	URL string `mapstructure:"url"`
+	// Ops 插件处理的操作 login、register 或 new_session
+	Ops []string `mapstructure:"ops"`
+	// Timeout 请求超时时间，单位秒，0 使用默认超时时间
+	Timeout int `mapstructure:"timeout"`
+	// FailOpen 请求失败时放行，默认拒绝
+	FailOpen bool `mapstructure:"fail_open"`
+}
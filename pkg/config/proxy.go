@@ -0,0 +1,10 @@
+package config
+
+// Proxy 客户端连接服务端使用的上游代理
+type Proxy struct {
+	// Type 代理类型 http 或 socks5
+	Type     string `mapstructure:"type"`
+	Addr     string `mapstructure:"addr"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password"`
+}
@@ -1,12 +1,33 @@
 package config
 
 type ServerConfig struct {
-	LogLevel    int    `mapstructure:"log_level"`
-	ServerBind  string `mapstructure:"server_bind"`
-	ServerPort  string `mapstructure:"server_port"`
-	Token       string `mapstructure:"token"`
-	AllowPorts  string `mapstructure:"allow_ports"`
-	ConnTimeout int    `mapstructure:"conn_timeout"`
+	LogLevel        int    `mapstructure:"log_level"`
+	ServerBind      string `mapstructure:"server_bind"`
+	ServerPort      string `mapstructure:"server_port"`
+	Transport       string `mapstructure:"transport"`
+	WebsocketPath   string `mapstructure:"websocket_path"`
+	TLSCertFile     string `mapstructure:"tls_cert_file"`
+	TLSKeyFile      string `mapstructure:"tls_key_file"`
+	KCP             KCP    `mapstructure:"kcp"`
+	Token           string `mapstructure:"token"`
+	AllowPorts      string `mapstructure:"allow_ports"`
+	ConnTimeout     int    `mapstructure:"conn_timeout"`
+	ShutdownTimeout int    `mapstructure:"shutdown_timeout"`
+	ResumeTimeout   int    `mapstructure:"resume_timeout"`
+	// UDPConnTimeout UDP 会话的空闲超时时间，ConnTimeout 只用于 TCP 会话
+	UDPConnTimeout int `mapstructure:"udp_conn_timeout"`
+	// UDPMTU UDP 隧道数据包的最大长度，超过后分片发送，QUIC 传输时不超过数据报能承载的长度
+	UDPMTU int `mapstructure:"udp_mtu"`
+	// UDPFragmentTimeout 分片重组超时时间，单位秒
+	UDPFragmentTimeout int `mapstructure:"udp_fragment_timeout"`
+	// UDPQueueSize UDP 服务每个队列的默认长度，客户端可以按服务设置
+	UDPQueueSize int `mapstructure:"udp_queue_size"`
+	// UDPQueuePolicy UDP 服务队列满时的默认处理策略 drop-newest、drop-oldest 或 block
+	UDPQueuePolicy string `mapstructure:"udp_queue_policy"`
+	// Auth 鉴权客户端的方式，默认使用 Token
+	Auth Auth `mapstructure:"auth"`
+	// Plugins 服务端插件，按顺序调用
+	Plugins []Plugin `mapstructure:"plugins"`
 }
 
 var ServerConf ServerConfig
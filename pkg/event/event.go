@@ -0,0 +1,49 @@
+package event
+
+import "time"
+
+// Type 事件类型，服务端和客户端共用
+type Type string
+
+const (
+	// Connected 控制连接握手完成，服务端为客户端连接，客户端为连接服务端
+	Connected Type = "connected"
+	// Disconnected 控制连接断开
+	Disconnected Type = "disconnected"
+	// ServiceRegistered 代理服务注册成功
+	ServiceRegistered Type = "service_registered"
+	// ServiceRejected 代理服务注册被拒绝，Reason 为拒绝原因
+	ServiceRejected Type = "service_rejected"
+	// ServiceClosed 代理服务关闭
+	ServiceClosed Type = "service_closed"
+	// SessionOpened 新的用户会话，客户端为新建隧道
+	SessionOpened Type = "session_opened"
+	// SessionClosed 用户会话关闭
+	SessionClosed Type = "session_closed"
+)
+
+// Event 运行事件
+type Event struct {
+	Type Type
+	Time time.Time
+	// ServiceID 代理服务 ID，和控制连接相关的事件为空
+	ServiceID string
+	// SessionID 用户会话 ID，只有会话事件有效
+	SessionID string
+	// Addr 对端地址，服务端为客户端或者用户地址，客户端为服务端地址
+	Addr string
+	// Reason 拒绝或者关闭的原因
+	Reason string
+}
+
+// Handler 事件回调，在产生事件的协程中同步调用，不能阻塞
+type Handler func(Event)
+
+// Emit 调用事件回调，没有设置回调时忽略
+func (h Handler) Emit(e Event) {
+	if h == nil {
+		return
+	}
+	e.Time = time.Now()
+	h(e)
+}
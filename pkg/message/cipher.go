@@ -0,0 +1,380 @@
+package message
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// cipherSaltSize 每一端的随机盐长度，用于派生连接密钥
+	cipherSaltSize = 32
+	// cipherMaxPayload 单个加密帧的最大数据长度
+	cipherMaxPayload = 16 * 1024
+	// packetSaltSize UDP 会话每一端的随机盐长度
+	packetSaltSize = 16
+	// PacketCipherOverhead 加密后 UDP 数据增加的长度，包括 1 字节类型
+	PacketCipherOverhead = 1 + chacha20poly1305.NonceSizeX + chacha20poly1305.Overhead
+)
+
+// 加密 UDP 数据包的类型
+const (
+	packetHello = iota + 1
+	packetHelloReply
+	packetData
+)
+
+var (
+	// ErrDecrypt 解密失败，通常是两端密钥不一致
+	ErrDecrypt = errors.New("decrypt tunnel data failed, key mismatch")
+	// ErrPacketHandshake UDP 会话还没有完成握手，或者握手不属于当前会话
+	ErrPacketHandshake = errors.New("encrypted packet session not established")
+)
+
+// deriveKey 使用 HKDF 从预共享密钥派生 AEAD 密钥
+func deriveKey(key string, salt []byte, info string) ([]byte, error) {
+	subKey := make([]byte, chacha20poly1305.KeySize)
+	_, err := io.ReadFull(hkdf.New(sha256.New, []byte(key), salt, []byte(info)), subKey)
+	if err != nil {
+		return nil, err
+	}
+	return subKey, nil
+}
+
+// CipherConn 端到端加密连接，服务端只转发密文
+// 双方先发送随机盐，连接密钥由预共享密钥和双方的盐派生，每个方向使用不同的密钥，避免密文被反射或者重放
+// 之后每帧为 2 字节数据长度加 AEAD 密文，数据长度作为附加数据参与认证
+type CipherConn struct {
+	net.Conn
+	key string
+	// server 为 true 时是注册代理服务的一端，否则是访问者
+	server        bool
+	onceHandshake sync.Once
+	handshakeErr  error
+	writeMx       sync.Mutex
+	writer        cipher.AEAD
+	// writeNonce 和 readNonce 为帧计数器，每个连接密钥只使用一次
+	writeNonce []byte
+	reader     cipher.AEAD
+	readNonce  []byte
+	readBuf    []byte
+}
+
+// NewCipherConn 使用预共享密钥封装连接，两端密钥一致才能通信，server 为 true 时是注册代理服务的一端
+func NewCipherConn(conn net.Conn, key string, server bool) *CipherConn {
+	return &CipherConn{Conn: conn, key: key, server: server}
+}
+
+// handshake 交换双方的随机盐并派生两个方向的连接密钥，第一次读写时执行
+func (c *CipherConn) handshake() error {
+	c.onceHandshake.Do(func() {
+		salt := make([]byte, cipherSaltSize)
+		_, err := rand.Read(salt)
+		if err != nil {
+			c.handshakeErr = err
+			return
+		}
+		_, err = c.Conn.Write(salt)
+		if err != nil {
+			c.handshakeErr = err
+			return
+		}
+		peerSalt := make([]byte, cipherSaltSize)
+		_, err = io.ReadFull(c.Conn, peerSalt)
+		if err != nil {
+			c.handshakeErr = err
+			return
+		}
+		salts := append(salt, peerSalt...)
+		if c.server {
+			salts = append(peerSalt, salt...)
+		}
+		c2s, err := newStreamAEAD(c.key, salts, "gnp stream c2s")
+		if err != nil {
+			c.handshakeErr = err
+			return
+		}
+		s2c, err := newStreamAEAD(c.key, salts, "gnp stream s2c")
+		if err != nil {
+			c.handshakeErr = err
+			return
+		}
+		c.writer, c.reader = c2s, s2c
+		if c.server {
+			c.writer, c.reader = s2c, c2s
+		}
+		c.writeNonce = make([]byte, c.writer.NonceSize())
+		c.readNonce = make([]byte, c.reader.NonceSize())
+	})
+	return c.handshakeErr
+}
+
+func newStreamAEAD(key string, salt []byte, info string) (cipher.AEAD, error) {
+	subKey, err := deriveKey(key, salt, info)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(subKey)
+}
+
+func (c *CipherConn) Write(b []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	err := c.handshake()
+	if err != nil {
+		return 0, err
+	}
+	var buf []byte
+	var n int
+	for n < len(b) {
+		payload := b[n:min(len(b), n+cipherMaxPayload)]
+		header := binary.BigEndian.AppendUint16(nil, uint16(len(payload)))
+		buf = append(buf, header...)
+		buf = c.writer.Seal(buf, c.writeNonce, payload, header)
+		increment(c.writeNonce)
+		n += len(payload)
+	}
+	_, err = c.Conn.Write(buf)
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (c *CipherConn) Read(b []byte) (int, error) {
+	if len(c.readBuf) == 0 {
+		err := c.handshake()
+		if err != nil {
+			return 0, err
+		}
+		header := make([]byte, 2)
+		_, err = io.ReadFull(c.Conn, header)
+		if err != nil {
+			return 0, err
+		}
+		frame := make([]byte, int(binary.BigEndian.Uint16(header))+c.reader.Overhead())
+		_, err = io.ReadFull(c.Conn, frame)
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf, err = c.reader.Open(frame[:0], c.readNonce, frame, header)
+		if err != nil {
+			return 0, ErrDecrypt
+		}
+		increment(c.readNonce)
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+// increment 小端序递增帧计数器
+func increment(nonce []byte) {
+	for i := range nonce {
+		nonce[i]++
+		if nonce[i] != 0 {
+			return
+		}
+	}
+}
+
+// PacketCipher 端到端加密 UDP 数据，每个会话一个实例，每个方向使用不同的密钥
+// 访问者先发送随机盐，代理服务一端回复自己的随机盐，会话密钥由预共享密钥和双方的盐派生，
+// 双方的盐作为会话 ID 参与每个数据包的认证，其他会话的数据包不能解密，也不能重放到新的会话
+// 数据包 nonce 为 8 字节发送序号加随机数，序号参与认证，接收时按序号防重放
+type PacketCipher struct {
+	key string
+	// server 为 true 时是注册代理服务的一端，否则是访问者
+	server bool
+	salt   []byte
+	mx     sync.Mutex
+	keys   atomic.Pointer[packetKeys]
+	// sendSeq 发送序号，从 1 开始
+	sendSeq atomic.Uint64
+	window  replayWindow
+}
+
+// packetKeys 握手完成后的会话密钥，reply 为代理服务一端的握手响应，重复的握手请求返回同样的响应
+type packetKeys struct {
+	sessionID []byte
+	sealer    cipher.AEAD
+	opener    cipher.AEAD
+	reply     []byte
+}
+
+// NewPacketCipher server 为 true 时是注册代理服务的一端，否则是访问者
+func NewPacketCipher(key string, server bool) (*PacketCipher, error) {
+	salt := make([]byte, packetSaltSize)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+	return &PacketCipher{key: key, server: server, salt: salt}, nil
+}
+
+// Hello 访问者的握手请求，收到响应前需要重复发送
+func (p *PacketCipher) Hello() ([]byte, error) {
+	buf := append([]byte{packetHello}, p.salt...)
+	aead, err := newPacketAEAD(p.key, p.salt, "gnp packet hello c2s")
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(buf, make([]byte, aead.NonceSize()), nil, buf), nil
+}
+
+// Ready 握手是否完成
+func (p *PacketCipher) Ready() bool {
+	return p.keys.Load() != nil
+}
+
+// setKeys 派生会话密钥，sessionID 为访问者的盐加代理服务一端的盐
+func (p *PacketCipher) setKeys(sessionID []byte) (*packetKeys, error) {
+	c2s, err := newPacketAEAD(p.key, sessionID, "gnp packet c2s")
+	if err != nil {
+		return nil, err
+	}
+	s2c, err := newPacketAEAD(p.key, sessionID, "gnp packet s2c")
+	if err != nil {
+		return nil, err
+	}
+	keys := &packetKeys{sessionID: sessionID, sealer: c2s, opener: s2c}
+	if p.server {
+		keys.sealer, keys.opener = s2c, c2s
+		aead, err := newPacketAEAD(p.key, sessionID, "gnp packet hello s2c")
+		if err != nil {
+			return nil, err
+		}
+		buf := append([]byte{packetHelloReply}, sessionID...)
+		keys.reply = aead.Seal(buf, make([]byte, aead.NonceSize()), nil, buf)
+	}
+	p.keys.Store(keys)
+	return keys, nil
+}
+
+func newPacketAEAD(key string, salt []byte, info string) (cipher.AEAD, error) {
+	subKey, err := deriveKey(key, salt, info)
+	if err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.NewX(subKey)
+}
+
+// Seal 加密数据，返回类型加 nonce 加密文，握手完成前返回 ErrPacketHandshake
+func (p *PacketCipher) Seal(data []byte) ([]byte, error) {
+	keys := p.keys.Load()
+	if keys == nil {
+		return nil, ErrPacketHandshake
+	}
+	buf := make([]byte, 1+keys.sealer.NonceSize(), PacketCipherOverhead+len(data))
+	buf[0] = packetData
+	nonce := buf[1:]
+	binary.BigEndian.PutUint64(nonce, p.sendSeq.Add(1))
+	_, err := rand.Read(nonce[8:])
+	if err != nil {
+		return nil, err
+	}
+	return keys.sealer.Seal(buf, nonce, data, append([]byte{packetData}, keys.sessionID...)), nil
+}
+
+// Open 解密数据，握手数据包返回空数据，代理服务一端同时返回需要发送给访问者的握手响应，
+// 重复或者过旧的数据包返回 ErrPacketReplay
+func (p *PacketCipher) Open(data []byte) ([]byte, []byte, error) {
+	if len(data) == 0 {
+		return nil, nil, ErrDecrypt
+	}
+	switch data[0] {
+	case packetHello:
+		reply, err := p.openHello(data)
+		return nil, reply, err
+	case packetHelloReply:
+		return nil, nil, p.openHelloReply(data)
+	case packetData:
+	default:
+		return nil, nil, ErrDecrypt
+	}
+	keys := p.keys.Load()
+	if keys == nil {
+		return nil, nil, ErrPacketHandshake
+	}
+	if len(data) < PacketCipherOverhead {
+		return nil, nil, errors.New("encrypted packet too short")
+	}
+	nonce, ciphertext := data[1:1+keys.opener.NonceSize()], data[1+keys.opener.NonceSize():]
+	data, err := keys.opener.Open(nil, nonce, ciphertext, append([]byte{packetData}, keys.sessionID...))
+	if err != nil {
+		return nil, nil, ErrDecrypt
+	}
+	if !p.window.check(binary.BigEndian.Uint64(nonce)) {
+		return nil, nil, ErrPacketReplay
+	}
+	if data == nil {
+		// 空数据包和握手数据包区分开
+		data = []byte{}
+	}
+	return data, nil, nil
+}
+
+// openHello 代理服务一端处理握手请求，会话只接受第一个有效的握手请求
+func (p *PacketCipher) openHello(data []byte) ([]byte, error) {
+	if !p.server || len(data) != 1+packetSaltSize+chacha20poly1305.Overhead {
+		return nil, ErrDecrypt
+	}
+	salt := data[1 : 1+packetSaltSize]
+	aead, err := newPacketAEAD(p.key, salt, "gnp packet hello c2s")
+	if err != nil {
+		return nil, err
+	}
+	_, err = aead.Open(nil, make([]byte, aead.NonceSize()), data[1+packetSaltSize:], data[:1+packetSaltSize])
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	keys := p.keys.Load()
+	if keys == nil {
+		keys, err = p.setKeys(append(append([]byte(nil), salt...), p.salt...))
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !bytes.Equal(keys.sessionID[:packetSaltSize], salt) {
+		return nil, ErrPacketHandshake
+	}
+	return keys.reply, nil
+}
+
+// openHelloReply 访问者处理握手响应，响应必须对应自己的握手请求
+func (p *PacketCipher) openHelloReply(data []byte) error {
+	if p.server || len(data) != 1+2*packetSaltSize+chacha20poly1305.Overhead || !bytes.Equal(data[1:1+packetSaltSize], p.salt) {
+		return ErrDecrypt
+	}
+	sessionID := data[1 : 1+2*packetSaltSize]
+	aead, err := newPacketAEAD(p.key, sessionID, "gnp packet hello s2c")
+	if err != nil {
+		return err
+	}
+	_, err = aead.Open(nil, make([]byte, aead.NonceSize()), data[1+2*packetSaltSize:], data[:1+2*packetSaltSize])
+	if err != nil {
+		return ErrDecrypt
+	}
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	keys := p.keys.Load()
+	if keys == nil {
+		_, err = p.setKeys(append([]byte(nil), sessionID...))
+		return err
+	}
+	if !bytes.Equal(keys.sessionID, sessionID) {
+		return ErrPacketHandshake
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package message
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// tamperConn 修改写入数据流中指定位置的字节，修改数据长度时改小，避免读取方等待不存在的数据
+type tamperConn struct {
+	net.Conn
+	offset  int
+	written int
+}
+
+func (c *tamperConn) Write(b []byte) (int, error) {
+	if c.offset >= c.written && c.offset < c.written+len(b) {
+		b = append([]byte(nil), b...)
+		b[c.offset-c.written] ^= 0x04
+	}
+	c.written += len(b)
+	return c.Conn.Write(b)
+}
+
+// tcpPipe 返回一对本地 TCP 连接，握手时两端同时写入，不能使用没有缓冲的 net.Pipe
+func tcpPipe(t *testing.T) (net.Conn, net.Conn) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = listener.Close()
+	}()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err := listener.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = client.Close()
+		_ = server.Close()
+	})
+	return client, server
+}
+
+func TestCipherConn(t *testing.T) {
+	data := make([]byte, 3*cipherMaxPayload+7)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	tests := []struct {
+		name       string
+		size       int
+		peerKey    string
+		peerServer bool
+		// tamper 修改访问者写入的第几个字节，0 表示不修改
+		tamper  int
+		wantErr error
+	}{
+		{"small", 1, "secret1", true, 0, nil},
+		{"one frame", cipherMaxPayload, "secret1", true, 0, nil},
+		{"multiple frames", len(data), "secret1", true, 0, nil},
+		{"key mismatch", 100, "secret2", true, 0, ErrDecrypt},
+		{"same role", 100, "secret1", false, 0, ErrDecrypt},
+		{"tampered length", 100, "secret1", true, cipherSaltSize + 2, ErrDecrypt},
+		{"tampered data", 100, "secret1", true, cipherSaltSize + 10, ErrDecrypt},
+		{"tampered salt", 100, "secret1", true, 1, ErrDecrypt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			visitorConn, serviceConn := tcpPipe(t)
+			if tt.tamper > 0 {
+				visitorConn = &tamperConn{Conn: visitorConn, offset: tt.tamper - 1}
+			}
+			visitor := NewCipherConn(visitorConn, "secret1", false)
+			service := NewCipherConn(serviceConn, tt.peerKey, tt.peerServer)
+			errCh := make(chan error, 1)
+			go func() {
+				_, err := visitor.Write(data[:tt.size])
+				errCh <- err
+				// 读取方校验失败前不会读取更多数据，关闭连接避免读取阻塞
+				if tt.wantErr != nil {
+					_ = visitorConn.Close()
+				}
+			}()
+			got := make([]byte, tt.size)
+			_, err := io.ReadFull(service, got)
+			if werr := <-errCh; werr != nil {
+				t.Fatalf("Write() error = %v", werr)
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Read() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, data[:tt.size]) {
+				t.Fatal("Read() data mismatch")
+			}
+		})
+	}
+}
+
+func TestCipherConnBothDirections(t *testing.T) {
+	visitorConn, serviceConn := tcpPipe(t)
+	visitor := NewCipherConn(visitorConn, "secret1", false)
+	service := NewCipherConn(serviceConn, "secret1", true)
+	go func() {
+		// 代理服务端回显数据
+		_, _ = io.Copy(service, service)
+	}()
+	for _, msg := range []string{"hello", "world"} {
+		_, err := visitor.Write([]byte(msg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]byte, len(msg))
+		_, err = io.ReadFull(visitor, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != msg {
+			t.Fatalf("Read() = %q, want %q", got, msg)
+		}
+	}
+}
+
+// newPacketCiphers 创建访问者和代理服务一端的 UDP 加密并完成握手
+func newPacketCiphers(t *testing.T, visitorKey, serviceKey string) (*PacketCipher, *PacketCipher) {
+	t.Helper()
+	visitor, err := NewPacketCipher(visitorKey, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewPacketCipher(serviceKey, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello, err := visitor.Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, reply, err := service.Open(hello)
+	if err != nil || data != nil || reply == nil {
+		t.Fatalf("service Open(hello) = %v, %v, %v", data, reply, err)
+	}
+	data, reply, err = visitor.Open(reply)
+	if err != nil || data != nil || reply != nil {
+		t.Fatalf("visitor Open(reply) = %v, %v, %v", data, reply, err)
+	}
+	if !visitor.Ready() || !service.Ready() {
+		t.Fatal("handshake not completed")
+	}
+	return visitor, service
+}
+
+func TestPacketCipherHandshake(t *testing.T) {
+	visitor, err := NewPacketCipher("secret1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := NewPacketCipher("secret1", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := visitor.Seal([]byte("hello")); !errors.Is(err, ErrPacketHandshake) {
+		t.Fatalf("Seal() before handshake error = %v, want %v", err, ErrPacketHandshake)
+	}
+	hello, err := visitor.Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := NewPacketCipher("secret1", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherHello, err := other.Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongKey, err := NewPacketCipher("secret2", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wrongHello, err := wrongKey.Hello()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := append([]byte(nil), hello...)
+	tampered[1] ^= 0xff
+	// 按顺序执行，代理服务一端只接受第一个有效的握手请求
+	tests := []struct {
+		name      string
+		packet    []byte
+		wantReply bool
+		wantErr   error
+	}{
+		{"wrong key", wrongHello, false, ErrDecrypt},
+		{"tampered", tampered, false, ErrDecrypt},
+		{"valid", hello, true, nil},
+		{"retransmit", hello, true, nil},
+		{"other session", otherHello, false, ErrPacketHandshake},
+	}
+	var reply []byte
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, got, err := service.Open(tt.packet)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if data != nil || (got != nil) != tt.wantReply {
+				t.Fatalf("Open() = %v, %v", data, got)
+			}
+			if got != nil {
+				if reply != nil && !bytes.Equal(reply, got) {
+					t.Fatal("Open() retransmitted hello reply changed")
+				}
+				reply = got
+			}
+		})
+	}
+	if _, _, err := other.Open(reply); !errors.Is(err, ErrDecrypt) {
+		t.Fatalf("other visitor Open(reply) error = %v, want %v", err, ErrDecrypt)
+	}
+	if _, _, err := visitor.Open(reply); err != nil {
+		t.Fatalf("visitor Open(reply) error = %v", err)
+	}
+	b, err := visitor.Seal([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _, err := service.Open(b); err != nil || string(data) != "hello" {
+		t.Fatalf("Open() = %q, %v", data, err)
+	}
+}
+
+func TestPacketCipher(t *testing.T) {
+	visitor, service := newPacketCiphers(t, "secret1", "secret1")
+	flip := func(i int) func([]byte) []byte {
+		return func(b []byte) []byte {
+			b[i] ^= 0xff
+			return b
+		}
+	}
+	tests := []struct {
+		name    string
+		sealer  *PacketCipher
+		data    []byte
+		modify  func([]byte) []byte
+		wantErr bool
+	}{
+		{"valid", visitor, []byte("hello"), func(b []byte) []byte { return b }, false},
+		{"empty", visitor, []byte{}, func(b []byte) []byte { return b }, false},
+		{"reflected", service, []byte("hello"), func(b []byte) []byte { return b }, true},
+		{"tampered type", visitor, []byte("hello"), flip(0), true},
+		{"tampered seq", visitor, []byte("hello"), flip(1), true},
+		{"tampered nonce", visitor, []byte("hello"), flip(10), true},
+		{"tampered data", visitor, []byte("hello"), flip(PacketCipherOverhead), true},
+		{"tampered tag", visitor, []byte("hello"), func(b []byte) []byte { return flip(len(b) - 1)(b) }, true},
+		{"too short", visitor, []byte("hello"), func(b []byte) []byte { return b[:PacketCipherOverhead-1] }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.sealer.Seal(tt.data)
+			if err != nil {
+				t.Fatalf("Seal() error = %v", err)
+			}
+			if len(b) != len(tt.data)+PacketCipherOverhead {
+				t.Fatalf("Seal() length = %d, want %d", len(b), len(tt.data)+PacketCipherOverhead)
+			}
+			got, _, err := service.Open(tt.modify(b))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Open() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got == nil || !bytes.Equal(got, tt.data)) {
+				t.Fatalf("Open() = %q, want %q", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestPacketCipherSessions(t *testing.T) {
+	visitor1, service1 := newPacketCiphers(t, "secret1", "secret1")
+	visitor2, service2 := newPacketCiphers(t, "secret1", "secret1")
+	tests := []struct {
+		name   string
+		sealer *PacketCipher
+		opener *PacketCipher
+	}{
+		{"visitor to other session", visitor1, service2},
+		{"other visitor to session", visitor2, service1},
+		{"service to other visitor", service1, visitor2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := tt.sealer.Seal([]byte("hello"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err := tt.opener.Open(b); !errors.Is(err, ErrDecrypt) {
+				t.Fatalf("Open() error = %v, want %v", err, ErrDecrypt)
+			}
+		})
+	}
+	t.Run("key mismatch", func(t *testing.T) {
+		visitor, err := NewPacketCipher("secret1", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		service, err := NewPacketCipher("secret2", true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hello, err := visitor.Hello()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := service.Open(hello); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("Open() error = %v, want %v", err, ErrDecrypt)
+		}
+	})
+}
+
+func TestPacketCipherReplay(t *testing.T) {
+	visitor, service := newPacketCiphers(t, "secret1", "secret1")
+	var packets [][]byte
+	for i := 0; i < 3; i++ {
+		b, err := visitor.Seal([]byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		packets = append(packets, b)
+	}
+	tests := []struct {
+		name    string
+		index   int
+		wantErr error
+	}{
+		{"newest", 2, nil},
+		{"out of order", 0, nil},
+		{"replayed", 2, ErrPacketReplay},
+		{"replayed old", 0, ErrPacketReplay},
+		{"late", 1, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _, err := service.Open(packets[tt.index])
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Open() error = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && !bytes.Equal(got, []byte{byte(tt.index)}) {
+				t.Fatalf("Open() = %v", got)
+			}
+		})
+	}
+	t.Run("replayed into new session", func(t *testing.T) {
+		// 新会话使用新的盐，旧会话的数据包不能解密
+		_, newService := newPacketCiphers(t, "secret1", "secret1")
+		if _, _, err := newService.Open(packets[0]); !errors.Is(err, ErrDecrypt) {
+			t.Fatalf("Open() error = %v, want %v", err, ErrDecrypt)
+		}
+	})
+}
@@ -0,0 +1,51 @@
+package message
+
+// TypedProtocolVersion 使用消息类型结构的协议版本，低于该版本的对端只能读取旧版本的共享字段
+const TypedProtocolVersion = 2
+
+// IsLegacy 对端是否只支持旧版本的共享字段
+func IsLegacy(hello *Hello) bool {
+	return hello.GetProtocolVersion() < TypedProtocolVersion
+}
+
+// upgrade 兼容旧版本协议，把共享字段转换为消息类型对应的结构
+func upgrade(msg *ControlMessage) {
+	if msg.Payload != nil {
+		return
+	}
+	switch msg.GetCtl() {
+	case NewService:
+		msg.Payload = &ControlMessage_Register{Register: &Register{Service: msg.GetService()}}
+	case ServiceReady:
+		msg.Payload = &ControlMessage_Ready{Ready: &Ready{Service: msg.GetService()}}
+	case NewTunnel:
+		msg.Payload = &ControlMessage_Tunnel{Tunnel: &Tunnel{Service: msg.GetService(), SessionID: msg.GetSessionID()}}
+	case KeepAlive:
+		msg.Payload = &ControlMessage_Heartbeat{Heartbeat: &Heartbeat{}}
+	case NewTunnelData:
+		msg.Payload = &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: msg.GetSessionID(), Data: msg.GetData()}}
+	}
+	msg.SessionID = ""
+	msg.Data = nil
+	msg.Service = nil
+}
+
+// Downgrade 和旧版本对端通信时，把消息类型对应的结构转换为共享字段
+func Downgrade(msg *ControlMessage) {
+	switch p := msg.Payload.(type) {
+	case *ControlMessage_Register:
+		msg.Service = p.Register.GetService()
+	case *ControlMessage_Ready:
+		msg.Service = p.Ready.GetService()
+	case *ControlMessage_Tunnel:
+		msg.Service = p.Tunnel.GetService()
+		msg.SessionID = p.Tunnel.GetSessionID()
+	case *ControlMessage_TunnelData:
+		msg.SessionID = p.TunnelData.GetSessionID()
+		msg.Data = p.TunnelData.GetData()
+	case *ControlMessage_Hello:
+		// 握手消息旧版本对端可以忽略
+		return
+	}
+	msg.Payload = nil
+}
@@ -0,0 +1,147 @@
+package message
+
+import (
+	"fmt"
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// 隧道数据压缩算法
+const (
+	CompressionSnappy = "snappy"
+	CompressionZstd   = "zstd"
+)
+
+// IsCompressionSupported 检查压缩算法是否支持，为空表示不压缩
+func IsCompressionSupported(compression string) bool {
+	switch compression {
+	case "", CompressionSnappy, CompressionZstd:
+		return true
+	}
+	return false
+}
+
+// CompressStats 压缩统计，Raw 为压缩前的字节数，Compressed 为隧道上传输的字节数
+type CompressStats struct {
+	Raw        atomic.Int64
+	Compressed atomic.Int64
+}
+
+// Ratio 压缩比，压缩后字节数和压缩前字节数的比值
+func (s *CompressStats) Ratio() float64 {
+	raw := s.Raw.Load()
+	if raw == 0 {
+		return 1
+	}
+	return float64(s.Compressed.Load()) / float64(raw)
+}
+
+func (s *CompressStats) String() string {
+	return fmt.Sprintf("raw=%d compressed=%d ratio=%.3f", s.Raw.Load(), s.Compressed.Load(), s.Ratio())
+}
+
+type compressWriter interface {
+	io.Writer
+	Flush() error
+}
+
+// CompressConn 压缩隧道连接，写入的数据压缩后立即发送，读取的数据解压后返回
+type CompressConn struct {
+	net.Conn
+	writer compressWriter
+	reader io.Reader
+	// release 释放解压缩资源，读取结束后调用，避免和正在进行的读取冲突
+	release     func()
+	onceRelease sync.Once
+	stats       []*CompressStats
+}
+
+// NewCompressConn 使用指定的压缩算法封装隧道连接，stats 累计压缩统计
+func NewCompressConn(conn net.Conn, compression string, stats ...*CompressStats) (*CompressConn, error) {
+	c := &CompressConn{Conn: conn, stats: stats, release: func() {}}
+	w := &countWriter{Writer: conn, c: c}
+	r := &countReader{Reader: conn, c: c}
+	switch compression {
+	case CompressionSnappy:
+		c.writer = snappy.NewBufferedWriter(w)
+		c.reader = snappy.NewReader(r)
+	case CompressionZstd:
+		enc, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1), zstd.WithEncoderLevel(zstd.SpeedFastest))
+		if err != nil {
+			return nil, err
+		}
+		dec, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		c.writer = enc
+		c.reader = dec
+		c.release = dec.Close
+	default:
+		return nil, fmt.Errorf("unsupported compression %s", compression)
+	}
+	return c, nil
+}
+
+func (c *CompressConn) Read(b []byte) (int, error) {
+	n, err := c.reader.Read(b)
+	c.addRaw(n)
+	if err != nil {
+		c.onceRelease.Do(c.release)
+	}
+	return n, err
+}
+
+func (c *CompressConn) Write(b []byte) (int, error) {
+	n, err := c.writer.Write(b)
+	if err != nil {
+		return n, err
+	}
+	// 隧道数据大多是交互式的，每次写入后立即发送
+	err = c.writer.Flush()
+	if err != nil {
+		return 0, err
+	}
+	c.addRaw(n)
+	return n, nil
+}
+
+func (c *CompressConn) addRaw(n int) {
+	for _, s := range c.stats {
+		s.Raw.Add(int64(n))
+	}
+}
+
+func (c *CompressConn) addCompressed(n int) {
+	for _, s := range c.stats {
+		s.Compressed.Add(int64(n))
+	}
+}
+
+// countWriter 统计压缩后写入隧道的字节数
+type countWriter struct {
+	io.Writer
+	c *CompressConn
+}
+
+func (w *countWriter) Write(b []byte) (int, error) {
+	n, err := w.Writer.Write(b)
+	w.c.addCompressed(n)
+	return n, err
+}
+
+// countReader 统计从隧道读取的压缩数据字节数
+type countReader struct {
+	io.Reader
+	c *CompressConn
+}
+
+func (r *countReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.c.addCompressed(n)
+	return n, err
+}
@@ -0,0 +1,142 @@
+package message
+
+import (
+	"google.golang.org/protobuf/proto"
+	"sync"
+	"time"
+)
+
+const (
+	// MaxUDPDataSize UDP 数据包最大长度
+	MaxUDPDataSize = 65535
+	// MinUDPMTU 分片时允许的最小 MTU，保证分片能容纳消息头
+	MinUDPMTU = 576
+	// maxFragments 单个数据包的最大分片数
+	maxFragments = 256
+	// maxPendingFragments 每个会话同时重组的最大数据包数量，超过后丢弃最早的
+	maxPendingFragments = 16
+)
+
+// Fragment 按 mtu 拆分 UDP 隧道数据，返回的每个消息序列化后不超过 mtu，不需要拆分时返回原消息
+func Fragment(msg *ControlMessage, mtu int, fragID uint32) []*ControlMessage {
+	data := msg.GetTunnelData().GetData()
+	// 复制不包含数据的消息头，避免复制数据
+	msg.GetTunnelData().Data = nil
+	base := proto.Clone(msg).(*ControlMessage)
+	msg.GetTunnelData().Data = data
+	// 按最大取值计算分片消息头的长度，数据字段的标签和长度最多占用 4 个字节，签名字段在分片后设置
+	header := proto.Clone(base).(*ControlMessage)
+	header.GetTunnelData().FragID = fragID
+	header.GetTunnelData().FragIndex = maxFragments
+	header.GetTunnelData().FragCount = maxFragments
+	header.Seq = 1 << 63
+	header.MAC = make([]byte, macSize)
+	size := mtu - proto.Size(header) - 4
+	if size <= 0 || len(data) <= size {
+		return []*ControlMessage{msg}
+	}
+	count := (len(data) + size - 1) / size
+	if count > maxFragments {
+		return []*ControlMessage{msg}
+	}
+	msgs := make([]*ControlMessage, 0, count)
+	for i := 0; i < count; i++ {
+		frag := proto.Clone(base).(*ControlMessage)
+		frag.GetTunnelData().Data = data[i*size : min(len(data), (i+1)*size)]
+		frag.GetTunnelData().FragID = fragID
+		frag.GetTunnelData().FragIndex = uint32(i)
+		frag.GetTunnelData().FragCount = uint32(count)
+		msgs = append(msgs, frag)
+	}
+	return msgs
+}
+
+// partial 正在重组的数据包
+type partial struct {
+	fragments [][]byte
+	received  int
+	size      int
+	expire    time.Time
+}
+
+// Reassembler 重组 UDP 隧道数据分片，超时未收齐的分片被丢弃
+type Reassembler struct {
+	mx       sync.Mutex
+	timeout  time.Duration
+	partials map[uint32]*partial
+	// order 按到达顺序记录数据包 ID，用于超出数量时丢弃最早的数据包
+	order []uint32
+}
+
+func NewReassembler(timeout time.Duration) *Reassembler {
+	return &Reassembler{
+		timeout:  timeout,
+		partials: make(map[uint32]*partial),
+	}
+}
+
+// Add 添加收到的隧道数据，未分片的数据直接返回，分片收齐后返回完整数据
+func (r *Reassembler) Add(data *TunnelData) ([]byte, bool) {
+	if data.GetFragCount() == 0 {
+		return data.GetData(), true
+	}
+	count, index := data.GetFragCount(), data.GetFragIndex()
+	if count > maxFragments || index >= count {
+		return nil, false
+	}
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	now := time.Now()
+	r.expire(now)
+	p, ok := r.partials[data.GetFragID()]
+	if !ok {
+		if len(r.order) >= maxPendingFragments {
+			delete(r.partials, r.order[0])
+			r.order = r.order[1:]
+		}
+		p = &partial{fragments: make([][]byte, count), expire: now.Add(r.timeout)}
+		r.partials[data.GetFragID()] = p
+		r.order = append(r.order, data.GetFragID())
+	}
+	if int(count) != len(p.fragments) || p.fragments[index] != nil {
+		return nil, false
+	}
+	if p.size+len(data.GetData()) > MaxUDPDataSize {
+		r.remove(data.GetFragID())
+		return nil, false
+	}
+	p.fragments[index] = data.GetData()
+	p.received++
+	p.size += len(data.GetData())
+	if p.received < len(p.fragments) {
+		return nil, false
+	}
+	r.remove(data.GetFragID())
+	buf := make([]byte, 0, p.size)
+	for _, fragment := range p.fragments {
+		buf = append(buf, fragment...)
+	}
+	return buf, true
+}
+
+// expire 丢弃超时的数据包
+func (r *Reassembler) expire(now time.Time) {
+	for len(r.order) > 0 {
+		p, ok := r.partials[r.order[0]]
+		if ok && p.expire.After(now) {
+			return
+		}
+		delete(r.partials, r.order[0])
+		r.order = r.order[1:]
+	}
+}
+
+func (r *Reassembler) remove(fragID uint32) {
+	delete(r.partials, fragID)
+	for i, id := range r.order {
+		if id == fragID {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package message
+
+import (
+	"bytes"
+	"google.golang.org/protobuf/proto"
+	"testing"
+	"time"
+)
+
+func newTunnelData(size int) *ControlMessage {
+	data := make([]byte, size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	return &ControlMessage{
+		Ctl:       NewTunnelData,
+		ServiceID: "udp16150",
+		Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: "s1", Data: data}},
+	}
+}
+
+func TestFragment(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		mtu       int
+		wantCount int
+	}{
+		{"small", 100, 1200, 1},
+		{"split", 3000, 1200, 3},
+		{"min mtu", 3000, MinUDPMTU, 6},
+		{"max size", MaxUDPDataSize, 1200, 58},
+		{"too many fragments", MaxUDPDataSize, 200, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newTunnelData(tt.size)
+			frags := Fragment(msg, tt.mtu, 7)
+			if len(frags) != tt.wantCount {
+				t.Fatalf("Fragment() count = %d, want %d", len(frags), tt.wantCount)
+			}
+			if len(frags) == 1 {
+				if frags[0] != msg {
+					t.Fatal("Fragment() without split should return original message")
+				}
+				return
+			}
+			var data []byte
+			for i, frag := range frags {
+				// 分片加上签名后也不能超过 mtu
+				frag.Seq = 1 << 63
+				frag.MAC = make([]byte, macSize)
+				if size := proto.Size(frag); size > tt.mtu {
+					t.Fatalf("fragment %d size %d exceeds mtu %d", i, size, tt.mtu)
+				}
+				td := frag.GetTunnelData()
+				if td.GetFragID() != 7 || td.GetFragIndex() != uint32(i) || td.GetFragCount() != uint32(len(frags)) {
+					t.Fatalf("fragment %d header = %d/%d/%d", i, td.GetFragID(), td.GetFragIndex(), td.GetFragCount())
+				}
+				data = append(data, td.GetData()...)
+			}
+			if !bytes.Equal(data, msg.GetTunnelData().GetData()) {
+				t.Fatal("fragments data mismatch")
+			}
+		})
+	}
+}
+
+func fragments(t *testing.T, size int, fragID uint32) []*TunnelData {
+	t.Helper()
+	var result []*TunnelData
+	for _, frag := range Fragment(newTunnelData(size), 1200, fragID) {
+		result = append(result, frag.GetTunnelData())
+	}
+	return result
+}
+
+func TestReassembler(t *testing.T) {
+	frags := fragments(t, 3000, 1)
+	want := newTunnelData(3000).GetTunnelData().GetData()
+	oversize := func(index uint32) *TunnelData {
+		return &TunnelData{FragID: 2, FragIndex: index, FragCount: 2, Data: make([]byte, MaxUDPDataSize/2+1)}
+	}
+	tests := []struct {
+		name string
+		data []*TunnelData
+		// want 每次添加后是否得到完整数据
+		want []bool
+	}{
+		{"unfragmented", []*TunnelData{{Data: want}}, []bool{true}},
+		{"in order", []*TunnelData{frags[0], frags[1], frags[2]}, []bool{false, false, true}},
+		{"out of order", []*TunnelData{frags[2], frags[0], frags[1]}, []bool{false, false, true}},
+		{"duplicate", []*TunnelData{frags[0], frags[0], frags[1], frags[1], frags[2]}, []bool{false, false, false, false, true}},
+		{"after complete", []*TunnelData{frags[0], frags[1], frags[2], frags[2]}, []bool{false, false, true, false}},
+		{"index out of range", []*TunnelData{{FragID: 1, FragIndex: 3, FragCount: 3}}, []bool{false}},
+		{"too many fragments", []*TunnelData{{FragID: 1, FragIndex: 0, FragCount: maxFragments + 1}}, []bool{false}},
+		{"count mismatch", []*TunnelData{frags[0], {FragID: 1, FragIndex: 1, FragCount: 2}, frags[1], frags[2]}, []bool{false, false, false, true}},
+		{"oversize", []*TunnelData{oversize(0), oversize(1), oversize(0)}, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := NewReassembler(time.Minute)
+			for i, data := range tt.data {
+				got, ok := r.Add(data)
+				if ok != tt.want[i] {
+					t.Fatalf("Add() at %d ok = %v, want %v", i, ok, tt.want[i])
+				}
+				if ok && data.GetFragCount() > 0 && !bytes.Equal(got, want) {
+					t.Fatalf("Add() at %d data mismatch", i)
+				}
+			}
+		})
+	}
+}
+
+func TestReassemblerLimits(t *testing.T) {
+	t.Run("expired", func(t *testing.T) {
+		r := NewReassembler(time.Millisecond)
+		frags := fragments(t, 3000, 1)
+		r.Add(frags[0])
+		r.Add(frags[1])
+		time.Sleep(10 * time.Millisecond)
+		if _, ok := r.Add(frags[2]); ok {
+			t.Fatal("Add() completed expired packet")
+		}
+	})
+	t.Run("pending limit", func(t *testing.T) {
+		r := NewReassembler(time.Minute)
+		first := fragments(t, 3000, 0)
+		r.Add(first[0])
+		r.Add(first[1])
+		for i := 1; i <= maxPendingFragments; i++ {
+			r.Add(fragments(t, 3000, uint32(i))[0])
+		}
+		if _, ok := r.Add(first[2]); ok {
+			t.Fatal("Add() completed dropped packet")
+		}
+		last := fragments(t, 3000, maxPendingFragments)
+		r.Add(last[1])
+		if _, ok := r.Add(last[2]); !ok {
+			t.Fatal("Add() dropped latest packet")
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package message
+
+import (
+	"fmt"
+)
+
+const (
+	// ProtocolVersion 当前协议版本
+	ProtocolVersion = 2
+	// MinProtocolVersion 兼容的最低协议版本，0 表示不支持握手的旧版本
+	MinProtocolVersion = 0
+)
+
+// Version 软件版本，编译时通过 -ldflags "-X gnp/pkg/message.Version=x.y.z" 设置
+var Version = "dev"
+
+// 连接握手协商的能力
+const (
+	CapTCP         = "tcp"
+	CapUDP         = "udp"
+	CapCompression = "compression"
+	// CapMux 同一个代理服务的 UDP 会话可以共用一个客户端 UDP 连接
+	CapMux = "mux"
+	// CapEncryption 代理服务可以使用端到端加密，服务端只转发密文
+	CapEncryption = "encryption"
+	// CapUDPAuth UDP 隧道数据包使用会话密钥签名
+	CapUDPAuth = "udp_auth"
+	// CapFragment UDP 隧道数据超过 MTU 时分片传输
+	CapFragment = "fragment"
+	// CapUDPOverTCP UDP 隧道数据可以通过 TCP 隧道连接传输
+	CapUDPOverTCP = "udp_over_tcp"
+	// CapCompactData UDP 隧道数据使用紧凑的二进制格式代替 protobuf
+	CapCompactData = "compact_data"
+)
+
+// Capabilities 当前版本支持的能力
+var Capabilities = []string{CapTCP, CapUDP, CapCompression, CapMux, CapEncryption, CapUDPAuth, CapFragment, CapUDPOverTCP, CapCompactData}
+
+// LegacyHello 不支持握手的旧版本，只支持 TCP 和 UDP 代理
+var LegacyHello = &Hello{
+	Version:      "legacy",
+	Capabilities: []string{CapTCP, CapUDP},
+}
+
+func NewHello() *Hello {
+	return &Hello{
+		ProtocolVersion:    ProtocolVersion,
+		MinProtocolVersion: MinProtocolVersion,
+		Version:            Version,
+		Capabilities:       Capabilities,
+	}
+}
+
+// Negotiate 协商协议版本和能力，返回双方都支持的协议版本和能力
+func Negotiate(local, remote *Hello) (*Hello, error) {
+	if remote.GetProtocolVersion() < local.GetMinProtocolVersion() {
+		return nil, fmt.Errorf("protocol version %d is lower than min version %d", remote.GetProtocolVersion(), local.GetMinProtocolVersion())
+	}
+	if local.GetProtocolVersion() < remote.GetMinProtocolVersion() {
+		return nil, fmt.Errorf("protocol version %d is lower than remote min version %d", local.GetProtocolVersion(), remote.GetMinProtocolVersion())
+	}
+	hello := &Hello{
+		ProtocolVersion:    min(local.GetProtocolVersion(), remote.GetProtocolVersion()),
+		MinProtocolVersion: local.GetMinProtocolVersion(),
+		Version:            local.GetVersion(),
+	}
+	for _, c := range local.GetCapabilities() {
+		if remote.HasCapability(c) {
+			hello.Capabilities = append(hello.Capabilities, c)
+		}
+	}
+	return hello, nil
+}
+
+// HasCapability 是否支持某项能力
+func (x *Hello) HasCapability(c string) bool {
+	for _, item := range x.GetCapabilities() {
+		if item == c {
+			return true
+		}
+	}
+	return false
+}
@@ -2,7 +2,9 @@ package message
 
 import (
 	"bufio"
+	"encoding/binary"
 	"errors"
+	"fmt"
 	"github.com/sanmuyan/xpkg/xnet"
 	"google.golang.org/protobuf/proto"
 	"io"
@@ -12,11 +14,15 @@ import (
 //go:generate protoc --go_out=../ *.proto
 
 const (
-	NewTunnel = iota + 10000
-	NewService
-	ServiceReady
-	KeepAlive
-	NewTunnelData
+	NewTunnel       = Ctl_NewTunnel
+	NewService      = Ctl_NewService
+	ServiceReady    = Ctl_ServiceReady
+	KeepAlive       = Ctl_KeepAlive
+	NewTunnelData   = Ctl_NewTunnelData
+	CloseService    = Ctl_CloseService
+	ServerShutdown  = Ctl_ServerShutdown
+	Handshake       = Ctl_Handshake
+	ServiceRejected = Ctl_ServiceRejected
 )
 
 const (
@@ -28,7 +34,12 @@ const (
 
 func Unmarshal(data []byte) (*ControlMessage, error) {
 	msg := new(ControlMessage)
-	return msg, proto.Unmarshal(data, msg)
+	err := proto.Unmarshal(data, msg)
+	if err != nil {
+		return msg, err
+	}
+	upgrade(msg)
+	return msg, nil
 }
 
 func Marshal(msg *ControlMessage) ([]byte, error) {
@@ -48,39 +59,26 @@ func WriteTCP(msg *ControlMessage, conn net.Conn) error {
 	return err
 }
 
+// maxTCPMessageSize TCP 消息的最大长度，UDP 隧道数据通过 TCP 隧道连接传输时单个消息可能超过读取缓冲区
+const maxTCPMessageSize = 1024 * 1024
+
+// ReadTCP 读取 4 字节小端序长度前缀的消息，和 xnet.Encode 的格式一致，消息不完整时等待剩余数据
 func ReadTCP(reader *bufio.Reader) (*ControlMessage, error) {
-	be, err := xnet.Decode(reader)
+	header := make([]byte, 4)
+	_, err := io.ReadFull(reader, header)
 	if err != nil {
 		return nil, err
 	}
-	return Unmarshal(be)
-}
-
-func WriteUDP(msg *ControlMessage, conn net.Conn) error {
-	bp, err := Marshal(msg)
-	if err != nil {
-		return err
+	length := binary.LittleEndian.Uint32(header)
+	if length > maxTCPMessageSize {
+		return nil, fmt.Errorf("tcp message too large %d", length)
 	}
-	_, err = conn.Write(bp)
-	return err
-}
-
-func WriteToUDP(msg *ControlMessage, conn *net.UDPConn, remoteAddr *net.UDPAddr) error {
-	bp, err := Marshal(msg)
-	if err != nil {
-		return err
-	}
-	_, err = conn.WriteToUDP(bp, remoteAddr)
-	return err
-}
-
-func ReadUDP(conn net.Conn) (*ControlMessage, error) {
-	buf := make([]byte, BufDataSize)
-	n, err := conn.Read(buf)
+	be := make([]byte, length)
+	_, err = io.ReadFull(reader, be)
 	if err != nil {
 		return nil, err
 	}
-	return Unmarshal(buf[:n])
+	return Unmarshal(be)
 }
 
 func Copy(dst, src net.Conn, resetTimeout func()) error {
@@ -20,6 +20,86 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// 消息类型，取值和旧版本 int32 类型的 Ctl 保持一致，保证线上格式兼容
+type Ctl int32
+
+const (
+	Ctl_Unknown Ctl = 0
+	// 新建隧道
+	Ctl_NewTunnel Ctl = 10000
+	// 注册代理服务
+	Ctl_NewService Ctl = 10001
+	// 代理服务注册成功
+	Ctl_ServiceReady Ctl = 10002
+	// 心跳
+	Ctl_KeepAlive Ctl = 10003
+	// UDP 隧道数据
+	Ctl_NewTunnelData Ctl = 10004
+	// 注销代理服务
+	Ctl_CloseService Ctl = 10005
+	// 服务端停机
+	Ctl_ServerShutdown Ctl = 10006
+	// 连接握手
+	Ctl_Handshake Ctl = 10007
+	// 代理服务注册被拒绝
+	Ctl_ServiceRejected Ctl = 10008
+)
+
+// Enum value maps for Ctl.
+var (
+	Ctl_name = map[int32]string{
+		0:     "Unknown",
+		10000: "NewTunnel",
+		10001: "NewService",
+		10002: "ServiceReady",
+		10003: "KeepAlive",
+		10004: "NewTunnelData",
+		10005: "CloseService",
+		10006: "ServerShutdown",
+		10007: "Handshake",
+		10008: "ServiceRejected",
+	}
+	Ctl_value = map[string]int32{
+		"Unknown":         0,
+		"NewTunnel":       10000,
+		"NewService":      10001,
+		"ServiceReady":    10002,
+		"KeepAlive":       10003,
+		"NewTunnelData":   10004,
+		"CloseService":    10005,
+		"ServerShutdown":  10006,
+		"Handshake":       10007,
+		"ServiceRejected": 10008,
+	}
+)
+
+func (x Ctl) Enum() *Ctl {
+	p := new(Ctl)
+	*p = x
+	return p
+}
+
+func (x Ctl) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Ctl) Descriptor() protoreflect.EnumDescriptor {
+	return file_message_proto_enumTypes[0].Descriptor()
+}
+
+func (Ctl) Type() protoreflect.EnumType {
+	return &file_message_proto_enumTypes[0]
+}
+
+func (x Ctl) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Ctl.Descriptor instead.
+func (Ctl) EnumDescriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{0}
+}
+
 type Service struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -28,6 +108,14 @@ type Service struct {
 	ProxyPort string `protobuf:"bytes,1,opt,name=ProxyPort,proto3" json:"ProxyPort,omitempty"`
 	LocalAddr string `protobuf:"bytes,2,opt,name=LocalAddr,proto3" json:"LocalAddr,omitempty"`
 	Network   string `protobuf:"bytes,3,opt,name=Network,proto3" json:"Network,omitempty"`
+	// 隧道数据压缩算法，为空表示不压缩
+	Compression string `protobuf:"bytes,4,opt,name=Compression,proto3" json:"Compression,omitempty"`
+	// UDP 服务每个队列的长度，为 0 时使用服务端配置
+	UDPQueueSize uint32 `protobuf:"varint,5,opt,name=UDPQueueSize,proto3" json:"UDPQueueSize,omitempty"`
+	// UDP 服务队列满时的处理策略 drop-newest、drop-oldest 或 block，为空时使用服务端配置
+	UDPQueuePolicy string `protobuf:"bytes,6,opt,name=UDPQueuePolicy,proto3" json:"UDPQueuePolicy,omitempty"`
+	// 会话空闲超时时间，单位秒，为 0 时按服务类型使用服务端配置
+	ConnTimeout uint32 `protobuf:"varint,7,opt,name=ConnTimeout,proto3" json:"ConnTimeout,omitempty"`
 }
 
 func (x *Service) Reset() {
@@ -83,29 +171,519 @@ func (x *Service) GetNetwork() string {
 	return ""
 }
 
+func (x *Service) GetCompression() string {
+	if x != nil {
+		return x.Compression
+	}
+	return ""
+}
+
+func (x *Service) GetUDPQueueSize() uint32 {
+	if x != nil {
+		return x.UDPQueueSize
+	}
+	return 0
+}
+
+func (x *Service) GetUDPQueuePolicy() string {
+	if x != nil {
+		return x.UDPQueuePolicy
+	}
+	return ""
+}
+
+func (x *Service) GetConnTimeout() uint32 {
+	if x != nil {
+		return x.ConnTimeout
+	}
+	return 0
+}
+
+// 连接握手，协商协议版本和能力
+type Hello struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// 协议版本
+	ProtocolVersion int32 `protobuf:"varint,1,opt,name=ProtocolVersion,proto3" json:"ProtocolVersion,omitempty"`
+	// 兼容的最低协议版本
+	MinProtocolVersion int32 `protobuf:"varint,2,opt,name=MinProtocolVersion,proto3" json:"MinProtocolVersion,omitempty"`
+	// 软件版本
+	Version string `protobuf:"bytes,3,opt,name=Version,proto3" json:"Version,omitempty"`
+	// 支持的能力
+	Capabilities []string `protobuf:"bytes,4,rep,name=Capabilities,proto3" json:"Capabilities,omitempty"`
+	// 握手失败原因
+	Error string `protobuf:"bytes,5,opt,name=Error,proto3" json:"Error,omitempty"`
+}
+
+func (x *Hello) Reset() {
+	*x = Hello{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Hello) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Hello) ProtoMessage() {}
+
+func (x *Hello) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Hello.ProtoReflect.Descriptor instead.
+func (*Hello) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Hello) GetProtocolVersion() int32 {
+	if x != nil {
+		return x.ProtocolVersion
+	}
+	return 0
+}
+
+func (x *Hello) GetMinProtocolVersion() int32 {
+	if x != nil {
+		return x.MinProtocolVersion
+	}
+	return 0
+}
+
+func (x *Hello) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *Hello) GetCapabilities() []string {
+	if x != nil {
+		return x.Capabilities
+	}
+	return nil
+}
+
+func (x *Hello) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+// 注册代理服务
+type Register struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Service *Service `protobuf:"bytes,1,opt,name=Service,proto3" json:"Service,omitempty"`
+}
+
+func (x *Register) Reset() {
+	*x = Register{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Register) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Register) ProtoMessage() {}
+
+func (x *Register) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Register.ProtoReflect.Descriptor instead.
+func (*Register) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Register) GetService() *Service {
+	if x != nil {
+		return x.Service
+	}
+	return nil
+}
+
+// 代理服务注册成功
+type Ready struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Service *Service `protobuf:"bytes,1,opt,name=Service,proto3" json:"Service,omitempty"`
+}
+
+func (x *Ready) Reset() {
+	*x = Ready{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Ready) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Ready) ProtoMessage() {}
+
+func (x *Ready) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Ready.ProtoReflect.Descriptor instead.
+func (*Ready) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Ready) GetService() *Service {
+	if x != nil {
+		return x.Service
+	}
+	return nil
+}
+
+// 代理服务注册被拒绝或者被服务端注销
+type Rejected struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Reason string `protobuf:"bytes,1,opt,name=Reason,proto3" json:"Reason,omitempty"`
+}
+
+func (x *Rejected) Reset() {
+	*x = Rejected{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Rejected) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Rejected) ProtoMessage() {}
+
+func (x *Rejected) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Rejected.ProtoReflect.Descriptor instead.
+func (*Rejected) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Rejected) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// 新建隧道
+type Tunnel struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Service *Service `protobuf:"bytes,1,opt,name=Service,proto3" json:"Service,omitempty"`
+	// 用户会话 ID
+	SessionID string `protobuf:"bytes,2,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
+	// UDP 会话密钥，通过控制连接下发，客户端用于签名和校验 UDP 隧道数据包
+	Key []byte `protobuf:"bytes,3,opt,name=Key,proto3" json:"Key,omitempty"`
+}
+
+func (x *Tunnel) Reset() {
+	*x = Tunnel{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Tunnel) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Tunnel) ProtoMessage() {}
+
+func (x *Tunnel) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Tunnel.ProtoReflect.Descriptor instead.
+func (*Tunnel) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *Tunnel) GetService() *Service {
+	if x != nil {
+		return x.Service
+	}
+	return nil
+}
+
+func (x *Tunnel) GetSessionID() string {
+	if x != nil {
+		return x.SessionID
+	}
+	return ""
+}
+
+func (x *Tunnel) GetKey() []byte {
+	if x != nil {
+		return x.Key
+	}
+	return nil
+}
+
+// 心跳，时间戳单位纳秒，用于计算往返时延
+type Heartbeat struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	SendTime  int64 `protobuf:"varint,1,opt,name=SendTime,proto3" json:"SendTime,omitempty"`
+	ReplyTime int64 `protobuf:"varint,2,opt,name=ReplyTime,proto3" json:"ReplyTime,omitempty"`
+}
+
+func (x *Heartbeat) Reset() {
+	*x = Heartbeat{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Heartbeat) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Heartbeat) ProtoMessage() {}
+
+func (x *Heartbeat) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Heartbeat.ProtoReflect.Descriptor instead.
+func (*Heartbeat) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Heartbeat) GetSendTime() int64 {
+	if x != nil {
+		return x.SendTime
+	}
+	return 0
+}
+
+func (x *Heartbeat) GetReplyTime() int64 {
+	if x != nil {
+		return x.ReplyTime
+	}
+	return 0
+}
+
+// UDP 隧道数据
+type TunnelData struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// 用户会话 ID
+	SessionID string `protobuf:"bytes,1,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
+	// 业务数据
+	Data []byte `protobuf:"bytes,2,opt,name=Data,proto3" json:"Data,omitempty"`
+	// 分片所属数据包 ID，不分片时为 0
+	FragID uint32 `protobuf:"varint,3,opt,name=FragID,proto3" json:"FragID,omitempty"`
+	// 分片序号，从 0 开始
+	FragIndex uint32 `protobuf:"varint,4,opt,name=FragIndex,proto3" json:"FragIndex,omitempty"`
+	// 分片总数，不分片时为 0
+	FragCount uint32 `protobuf:"varint,5,opt,name=FragCount,proto3" json:"FragCount,omitempty"`
+}
+
+func (x *TunnelData) Reset() {
+	*x = TunnelData{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_message_proto_msgTypes[7]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TunnelData) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TunnelData) ProtoMessage() {}
+
+func (x *TunnelData) ProtoReflect() protoreflect.Message {
+	mi := &file_message_proto_msgTypes[7]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TunnelData.ProtoReflect.Descriptor instead.
+func (*TunnelData) Descriptor() ([]byte, []int) {
+	return file_message_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *TunnelData) GetSessionID() string {
+	if x != nil {
+		return x.SessionID
+	}
+	return ""
+}
+
+func (x *TunnelData) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *TunnelData) GetFragID() uint32 {
+	if x != nil {
+		return x.FragID
+	}
+	return 0
+}
+
+func (x *TunnelData) GetFragIndex() uint32 {
+	if x != nil {
+		return x.FragIndex
+	}
+	return 0
+}
+
+func (x *TunnelData) GetFragCount() uint32 {
+	if x != nil {
+		return x.FragCount
+	}
+	return 0
+}
+
 type ControlMessage struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
 	// 消息类型
-	Ctl int32 `protobuf:"varint,1,opt,name=Ctl,proto3" json:"Ctl,omitempty"`
+	Ctl Ctl `protobuf:"varint,1,opt,name=Ctl,proto3,enum=Ctl" json:"Ctl,omitempty"`
 	// 代理服务 ID
 	ServiceID string `protobuf:"bytes,2,opt,name=ServiceID,proto3" json:"ServiceID,omitempty"`
+	// 鉴权 Token
+	Token string `protobuf:"bytes,7,opt,name=Token,proto3" json:"Token,omitempty"`
+	// 客户端 ID，控制连接断开重连后用于恢复代理服务
+	ClientID string `protobuf:"bytes,8,opt,name=ClientID,proto3" json:"ClientID,omitempty"`
+	// 消息内容，根据消息类型使用对应的结构
+	//
+	// Types that are assignable to Payload:
+	//	*ControlMessage_Hello
+	//	*ControlMessage_Register
+	//	*ControlMessage_Ready
+	//	*ControlMessage_Rejected
+	//	*ControlMessage_Tunnel
+	//	*ControlMessage_Heartbeat
+	//	*ControlMessage_TunnelData
+	Payload isControlMessage_Payload `protobuf_oneof:"Payload"`
+	// UDP 隧道数据包序号，用于防重放
+	Seq uint64 `protobuf:"varint,16,opt,name=Seq,proto3" json:"Seq,omitempty"`
+	// UDP 隧道数据包签名
+	MAC []byte `protobuf:"bytes,17,opt,name=MAC,proto3" json:"MAC,omitempty"`
+	// 客户端声明的用户名，服务端按用户鉴权时使用
+	User string `protobuf:"bytes,18,opt,name=User,proto3" json:"User,omitempty"`
+	// 以下为旧版本协议的共享字段，只在和旧版本通信时使用
 	// UDP 会话 ID
+	//
+	// Deprecated: Do not use.
 	SessionID string `protobuf:"bytes,3,opt,name=SessionID,proto3" json:"SessionID,omitempty"`
 	// 业务数据
+	//
+	// Deprecated: Do not use.
 	Data []byte `protobuf:"bytes,5,opt,name=Data,proto3" json:"Data,omitempty"`
 	// 注册代理服务
+	//
+	// Deprecated: Do not use.
 	Service *Service `protobuf:"bytes,6,opt,name=Service,proto3" json:"Service,omitempty"`
-	// 鉴权 Token
-	Token string `protobuf:"bytes,7,opt,name=Token,proto3" json:"Token,omitempty"`
 }
 
 func (x *ControlMessage) Reset() {
 	*x = ControlMessage{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_message_proto_msgTypes[1]
+		mi := &file_message_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -118,7 +696,7 @@ func (x *ControlMessage) String() string {
 func (*ControlMessage) ProtoMessage() {}
 
 func (x *ControlMessage) ProtoReflect() protoreflect.Message {
-	mi := &file_message_proto_msgTypes[1]
+	mi := &file_message_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -131,14 +709,14 @@ func (x *ControlMessage) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ControlMessage.ProtoReflect.Descriptor instead.
 func (*ControlMessage) Descriptor() ([]byte, []int) {
-	return file_message_proto_rawDescGZIP(), []int{1}
+	return file_message_proto_rawDescGZIP(), []int{8}
 }
 
-func (x *ControlMessage) GetCtl() int32 {
+func (x *ControlMessage) GetCtl() Ctl {
 	if x != nil {
 		return x.Ctl
 	}
-	return 0
+	return Ctl_Unknown
 }
 
 func (x *ControlMessage) GetServiceID() string {
@@ -148,6 +726,98 @@ func (x *ControlMessage) GetServiceID() string {
 	return ""
 }
 
+func (x *ControlMessage) GetToken() string {
+	if x != nil {
+		return x.Token
+	}
+	return ""
+}
+
+func (x *ControlMessage) GetClientID() string {
+	if x != nil {
+		return x.ClientID
+	}
+	return ""
+}
+
+func (m *ControlMessage) GetPayload() isControlMessage_Payload {
+	if m != nil {
+		return m.Payload
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetHello() *Hello {
+	if x, ok := x.GetPayload().(*ControlMessage_Hello); ok {
+		return x.Hello
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetRegister() *Register {
+	if x, ok := x.GetPayload().(*ControlMessage_Register); ok {
+		return x.Register
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetReady() *Ready {
+	if x, ok := x.GetPayload().(*ControlMessage_Ready); ok {
+		return x.Ready
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetRejected() *Rejected {
+	if x, ok := x.GetPayload().(*ControlMessage_Rejected); ok {
+		return x.Rejected
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetTunnel() *Tunnel {
+	if x, ok := x.GetPayload().(*ControlMessage_Tunnel); ok {
+		return x.Tunnel
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetHeartbeat() *Heartbeat {
+	if x, ok := x.GetPayload().(*ControlMessage_Heartbeat); ok {
+		return x.Heartbeat
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetTunnelData() *TunnelData {
+	if x, ok := x.GetPayload().(*ControlMessage_TunnelData); ok {
+		return x.TunnelData
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *ControlMessage) GetMAC() []byte {
+	if x != nil {
+		return x.MAC
+	}
+	return nil
+}
+
+func (x *ControlMessage) GetUser() string {
+	if x != nil {
+		return x.User
+	}
+	return ""
+}
+
+// Deprecated: Do not use.
 func (x *ControlMessage) GetSessionID() string {
 	if x != nil {
 		return x.SessionID
@@ -155,6 +825,7 @@ func (x *ControlMessage) GetSessionID() string {
 	return ""
 }
 
+// Deprecated: Do not use.
 func (x *ControlMessage) GetData() []byte {
 	if x != nil {
 		return x.Data
@@ -162,6 +833,7 @@ func (x *ControlMessage) GetData() []byte {
 	return nil
 }
 
+// Deprecated: Do not use.
 func (x *ControlMessage) GetService() *Service {
 	if x != nil {
 		return x.Service
@@ -169,34 +841,157 @@ func (x *ControlMessage) GetService() *Service {
 	return nil
 }
 
-func (x *ControlMessage) GetToken() string {
-	if x != nil {
-		return x.Token
-	}
-	return ""
+type isControlMessage_Payload interface {
+	isControlMessage_Payload()
+}
+
+type ControlMessage_Hello struct {
+	Hello *Hello `protobuf:"bytes,9,opt,name=Hello,proto3,oneof"`
+}
+
+type ControlMessage_Register struct {
+	Register *Register `protobuf:"bytes,10,opt,name=Register,proto3,oneof"`
+}
+
+type ControlMessage_Ready struct {
+	Ready *Ready `protobuf:"bytes,11,opt,name=Ready,proto3,oneof"`
+}
+
+type ControlMessage_Rejected struct {
+	Rejected *Rejected `protobuf:"bytes,12,opt,name=Rejected,proto3,oneof"`
+}
+
+type ControlMessage_Tunnel struct {
+	Tunnel *Tunnel `protobuf:"bytes,13,opt,name=Tunnel,proto3,oneof"`
+}
+
+type ControlMessage_Heartbeat struct {
+	Heartbeat *Heartbeat `protobuf:"bytes,14,opt,name=Heartbeat,proto3,oneof"`
+}
+
+type ControlMessage_TunnelData struct {
+	TunnelData *TunnelData `protobuf:"bytes,15,opt,name=TunnelData,proto3,oneof"`
 }
 
+func (*ControlMessage_Hello) isControlMessage_Payload() {}
+
+func (*ControlMessage_Register) isControlMessage_Payload() {}
+
+func (*ControlMessage_Ready) isControlMessage_Payload() {}
+
+func (*ControlMessage_Rejected) isControlMessage_Payload() {}
+
+func (*ControlMessage_Tunnel) isControlMessage_Payload() {}
+
+func (*ControlMessage_Heartbeat) isControlMessage_Payload() {}
+
+func (*ControlMessage_TunnelData) isControlMessage_Payload() {}
+
 var File_message_proto protoreflect.FileDescriptor
 
 var file_message_proto_rawDesc = []byte{
 	0x0a, 0x0d, 0x6d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22,
-	0x5f, 0x0a, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x50, 0x72,
-	0x6f, 0x78, 0x79, 0x50, 0x6f, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x50,
-	0x72, 0x6f, 0x78, 0x79, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x4c, 0x6f, 0x63, 0x61,
-	0x6c, 0x41, 0x64, 0x64, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x4c, 0x6f, 0x63,
-	0x61, 0x6c, 0x41, 0x64, 0x64, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72,
-	0x6b, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72, 0x6b,
-	0x22, 0xac, 0x01, 0x0a, 0x0e, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x4d, 0x65, 0x73, 0x73,
-	0x61, 0x67, 0x65, 0x12, 0x10, 0x0a, 0x03, 0x43, 0x74, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05,
-	0x52, 0x03, 0x43, 0x74, 0x6c, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
-	0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63,
-	0x65, 0x49, 0x44, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49,
-	0x44, 0x12, 0x12, 0x0a, 0x04, 0x44, 0x61, 0x74, 0x61, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x52,
-	0x04, 0x44, 0x61, 0x74, 0x61, 0x12, 0x22, 0x0a, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
-	0x18, 0x06, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x08, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
-	0x52, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x14, 0x0a, 0x05, 0x54, 0x6f, 0x6b,
-	0x65, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x32,
+	0xef, 0x01, 0x0a, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x50,
+	0x72, 0x6f, 0x78, 0x79, 0x50, 0x6f, 0x72, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09,
+	0x50, 0x72, 0x6f, 0x78, 0x79, 0x50, 0x6f, 0x72, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x4c, 0x6f, 0x63,
+	0x61, 0x6c, 0x41, 0x64, 0x64, 0x72, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x4c, 0x6f,
+	0x63, 0x61, 0x6c, 0x41, 0x64, 0x64, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x4e, 0x65, 0x74, 0x77, 0x6f,
+	0x72, 0x6b, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x4e, 0x65, 0x74, 0x77, 0x6f, 0x72,
+	0x6b, 0x12, 0x20, 0x0a, 0x0b, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e,
+	0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73,
+	0x69, 0x6f, 0x6e, 0x12, 0x22, 0x0a, 0x0c, 0x55, 0x44, 0x50, 0x51, 0x75, 0x65, 0x75, 0x65, 0x53,
+	0x69, 0x7a, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x0c, 0x55, 0x44, 0x50, 0x51, 0x75,
+	0x65, 0x75, 0x65, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x26, 0x0a, 0x0e, 0x55, 0x44, 0x50, 0x51, 0x75,
+	0x65, 0x75, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x0e, 0x55, 0x44, 0x50, 0x51, 0x75, 0x65, 0x75, 0x65, 0x50, 0x6f, 0x6c, 0x69, 0x63, 0x79, 0x12,
+	0x20, 0x0a, 0x0b, 0x43, 0x6f, 0x6e, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75, 0x74, 0x18, 0x07,
+	0x20, 0x01, 0x28, 0x0d, 0x52, 0x0b, 0x43, 0x6f, 0x6e, 0x6e, 0x54, 0x69, 0x6d, 0x65, 0x6f, 0x75,
+	0x74, 0x22, 0xb5, 0x01, 0x0a, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x12, 0x28, 0x0a, 0x0f, 0x50,
+	0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x05, 0x52, 0x0f, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2e, 0x0a, 0x12, 0x4d, 0x69, 0x6e, 0x50, 0x72, 0x6f, 0x74,
+	0x6f, 0x63, 0x6f, 0x6c, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x12, 0x4d, 0x69, 0x6e, 0x50, 0x72, 0x6f, 0x74, 0x6f, 0x63, 0x6f, 0x6c, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12,
+	0x22, 0x0a, 0x0c, 0x43, 0x61, 0x70, 0x61, 0x62, 0x69, 0x6c, 0x69, 0x74, 0x69, 0x65, 0x73, 0x18,
+	0x04, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0c, 0x43, 0x61, 0x70, 0x61, 0x62, 0x69, 0x6c, 0x69, 0x74,
+	0x69, 0x65, 0x73, 0x12, 0x14, 0x0a, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x05, 0x45, 0x72, 0x72, 0x6f, 0x72, 0x22, 0x2e, 0x0a, 0x08, 0x52, 0x65, 0x67,
+	0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x22, 0x0a, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x08, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65,
+	0x52, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x22, 0x2b, 0x0a, 0x05, 0x52, 0x65, 0x61,
+	0x64, 0x79, 0x12, 0x22, 0x0a, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x08, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x52, 0x07, 0x53,
+	0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x22, 0x22, 0x0a, 0x08, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74,
+	0x65, 0x64, 0x12, 0x16, 0x0a, 0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x06, 0x52, 0x65, 0x61, 0x73, 0x6f, 0x6e, 0x22, 0x5c, 0x0a, 0x06, 0x54, 0x75,
+	0x6e, 0x6e, 0x65, 0x6c, 0x12, 0x22, 0x0a, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x08, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x52,
+	0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x53, 0x65, 0x73, 0x73,
+	0x69, 0x6f, 0x6e, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x53, 0x65, 0x73,
+	0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x12, 0x10, 0x0a, 0x03, 0x4b, 0x65, 0x79, 0x18, 0x03, 0x20,
+	0x01, 0x28, 0x0c, 0x52, 0x03, 0x4b, 0x65, 0x79, 0x22, 0x45, 0x0a, 0x09, 0x48, 0x65, 0x61, 0x72,
+	0x74, 0x62, 0x65, 0x61, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x53, 0x65, 0x6e, 0x64, 0x54, 0x69, 0x6d,
+	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x53, 0x65, 0x6e, 0x64, 0x54, 0x69, 0x6d,
+	0x65, 0x12, 0x1c, 0x0a, 0x09, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x54, 0x69, 0x6d, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x52, 0x65, 0x70, 0x6c, 0x79, 0x54, 0x69, 0x6d, 0x65, 0x22,
+	0x92, 0x01, 0x0a, 0x0a, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1c,
+	0x0a, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x12, 0x12, 0x0a, 0x04,
+	0x44, 0x61, 0x74, 0x61, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x04, 0x44, 0x61, 0x74, 0x61,
+	0x12, 0x16, 0x0a, 0x06, 0x46, 0x72, 0x61, 0x67, 0x49, 0x44, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0d,
+	0x52, 0x06, 0x46, 0x72, 0x61, 0x67, 0x49, 0x44, 0x12, 0x1c, 0x0a, 0x09, 0x46, 0x72, 0x61, 0x67,
+	0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x46, 0x72, 0x61,
+	0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x1c, 0x0a, 0x09, 0x46, 0x72, 0x61, 0x67, 0x43, 0x6f,
+	0x75, 0x6e, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0d, 0x52, 0x09, 0x46, 0x72, 0x61, 0x67, 0x43,
+	0x6f, 0x75, 0x6e, 0x74, 0x22, 0xad, 0x04, 0x0a, 0x0e, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c,
+	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x16, 0x0a, 0x03, 0x43, 0x74, 0x6c, 0x18, 0x01,
+	0x20, 0x01, 0x28, 0x0e, 0x32, 0x04, 0x2e, 0x43, 0x74, 0x6c, 0x52, 0x03, 0x43, 0x74, 0x6c, 0x12,
+	0x1c, 0x0a, 0x09, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x49, 0x44, 0x18, 0x02, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x09, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x49, 0x44, 0x12, 0x14, 0x0a,
+	0x05, 0x54, 0x6f, 0x6b, 0x65, 0x6e, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x54, 0x6f,
+	0x6b, 0x65, 0x6e, 0x12, 0x1a, 0x0a, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x18,
+	0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x49, 0x44, 0x12,
+	0x1e, 0x0a, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x18, 0x09, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x06,
+	0x2e, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x48, 0x00, 0x52, 0x05, 0x48, 0x65, 0x6c, 0x6c, 0x6f, 0x12,
+	0x27, 0x0a, 0x08, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x18, 0x0a, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x09, 0x2e, 0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x48, 0x00, 0x52, 0x08,
+	0x52, 0x65, 0x67, 0x69, 0x73, 0x74, 0x65, 0x72, 0x12, 0x1e, 0x0a, 0x05, 0x52, 0x65, 0x61, 0x64,
+	0x79, 0x18, 0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x06, 0x2e, 0x52, 0x65, 0x61, 0x64, 0x79, 0x48,
+	0x00, 0x52, 0x05, 0x52, 0x65, 0x61, 0x64, 0x79, 0x12, 0x27, 0x0a, 0x08, 0x52, 0x65, 0x6a, 0x65,
+	0x63, 0x74, 0x65, 0x64, 0x18, 0x0c, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x09, 0x2e, 0x52, 0x65, 0x6a,
+	0x65, 0x63, 0x74, 0x65, 0x64, 0x48, 0x00, 0x52, 0x08, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x65,
+	0x64, 0x12, 0x21, 0x0a, 0x06, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x18, 0x0d, 0x20, 0x01, 0x28,
+	0x0b, 0x32, 0x07, 0x2e, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x48, 0x00, 0x52, 0x06, 0x54, 0x75,
+	0x6e, 0x6e, 0x65, 0x6c, 0x12, 0x2a, 0x0a, 0x09, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61,
+	0x74, 0x18, 0x0e, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0a, 0x2e, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62,
+	0x65, 0x61, 0x74, 0x48, 0x00, 0x52, 0x09, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74,
+	0x12, 0x2d, 0x0a, 0x0a, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x44, 0x61, 0x74, 0x61, 0x18, 0x0f,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0b, 0x2e, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x44, 0x61, 0x74,
+	0x61, 0x48, 0x00, 0x52, 0x0a, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x44, 0x61, 0x74, 0x61, 0x12,
+	0x10, 0x0a, 0x03, 0x53, 0x65, 0x71, 0x18, 0x10, 0x20, 0x01, 0x28, 0x04, 0x52, 0x03, 0x53, 0x65,
+	0x71, 0x12, 0x10, 0x0a, 0x03, 0x4d, 0x41, 0x43, 0x18, 0x11, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x03,
+	0x4d, 0x41, 0x43, 0x12, 0x12, 0x0a, 0x04, 0x55, 0x73, 0x65, 0x72, 0x18, 0x12, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x04, 0x55, 0x73, 0x65, 0x72, 0x12, 0x20, 0x0a, 0x09, 0x53, 0x65, 0x73, 0x73, 0x69,
+	0x6f, 0x6e, 0x49, 0x44, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x42, 0x02, 0x18, 0x01, 0x52, 0x09,
+	0x53, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x49, 0x44, 0x12, 0x16, 0x0a, 0x04, 0x44, 0x61, 0x74,
+	0x61, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0c, 0x42, 0x02, 0x18, 0x01, 0x52, 0x04, 0x44, 0x61, 0x74,
+	0x61, 0x12, 0x26, 0x0a, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x18, 0x06, 0x20, 0x01,
+	0x28, 0x0b, 0x32, 0x08, 0x2e, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x42, 0x02, 0x18, 0x01,
+	0x52, 0x07, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x42, 0x09, 0x0a, 0x07, 0x50, 0x61, 0x79,
+	0x6c, 0x6f, 0x61, 0x64, 0x2a, 0xb8, 0x01, 0x0a, 0x03, 0x43, 0x74, 0x6c, 0x12, 0x0b, 0x0a, 0x07,
+	0x55, 0x6e, 0x6b, 0x6e, 0x6f, 0x77, 0x6e, 0x10, 0x00, 0x12, 0x0e, 0x0a, 0x09, 0x4e, 0x65, 0x77,
+	0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x10, 0x90, 0x4e, 0x12, 0x0f, 0x0a, 0x0a, 0x4e, 0x65, 0x77,
+	0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x10, 0x91, 0x4e, 0x12, 0x11, 0x0a, 0x0c, 0x53, 0x65,
+	0x72, 0x76, 0x69, 0x63, 0x65, 0x52, 0x65, 0x61, 0x64, 0x79, 0x10, 0x92, 0x4e, 0x12, 0x0e, 0x0a,
+	0x09, 0x4b, 0x65, 0x65, 0x70, 0x41, 0x6c, 0x69, 0x76, 0x65, 0x10, 0x93, 0x4e, 0x12, 0x12, 0x0a,
+	0x0d, 0x4e, 0x65, 0x77, 0x54, 0x75, 0x6e, 0x6e, 0x65, 0x6c, 0x44, 0x61, 0x74, 0x61, 0x10, 0x94,
+	0x4e, 0x12, 0x11, 0x0a, 0x0c, 0x43, 0x6c, 0x6f, 0x73, 0x65, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63,
+	0x65, 0x10, 0x95, 0x4e, 0x12, 0x13, 0x0a, 0x0e, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x53, 0x68,
+	0x75, 0x74, 0x64, 0x6f, 0x77, 0x6e, 0x10, 0x96, 0x4e, 0x12, 0x0e, 0x0a, 0x09, 0x48, 0x61, 0x6e,
+	0x64, 0x73, 0x68, 0x61, 0x6b, 0x65, 0x10, 0x97, 0x4e, 0x12, 0x14, 0x0a, 0x0f, 0x53, 0x65, 0x72,
+	0x76, 0x69, 0x63, 0x65, 0x52, 0x65, 0x6a, 0x65, 0x63, 0x74, 0x65, 0x64, 0x10, 0x98, 0x4e, 0x32,
 	0x48, 0x0a, 0x0f, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63,
 	0x65, 0x73, 0x12, 0x35, 0x0a, 0x0d, 0x48, 0x61, 0x6e, 0x64, 0x6c, 0x65, 0x4d, 0x65, 0x73, 0x73,
 	0x61, 0x67, 0x65, 0x12, 0x0f, 0x2e, 0x43, 0x6f, 0x6e, 0x74, 0x72, 0x6f, 0x6c, 0x4d, 0x65, 0x73,
@@ -217,20 +1012,40 @@ func file_message_proto_rawDescGZIP() []byte {
 	return file_message_proto_rawDescData
 }
 
-var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_message_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_message_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_message_proto_goTypes = []interface{}{
-	(*Service)(nil),        // 0: Service
-	(*ControlMessage)(nil), // 1: ControlMessage
+	(Ctl)(0),               // 0: Ctl
+	(*Service)(nil),        // 1: Service
+	(*Hello)(nil),          // 2: Hello
+	(*Register)(nil),       // 3: Register
+	(*Ready)(nil),          // 4: Ready
+	(*Rejected)(nil),       // 5: Rejected
+	(*Tunnel)(nil),         // 6: Tunnel
+	(*Heartbeat)(nil),      // 7: Heartbeat
+	(*TunnelData)(nil),     // 8: TunnelData
+	(*ControlMessage)(nil), // 9: ControlMessage
 }
 var file_message_proto_depIdxs = []int32{
-	0, // 0: ControlMessage.Service:type_name -> Service
-	1, // 1: ControlServices.HandleMessage:input_type -> ControlMessage
-	1, // 2: ControlServices.HandleMessage:output_type -> ControlMessage
-	2, // [2:3] is the sub-list for method output_type
-	1, // [1:2] is the sub-list for method input_type
-	1, // [1:1] is the sub-list for extension type_name
-	1, // [1:1] is the sub-list for extension extendee
-	0, // [0:1] is the sub-list for field type_name
+	1,  // 0: Register.Service:type_name -> Service
+	1,  // 1: Ready.Service:type_name -> Service
+	1,  // 2: Tunnel.Service:type_name -> Service
+	0,  // 3: ControlMessage.Ctl:type_name -> Ctl
+	2,  // 4: ControlMessage.Hello:type_name -> Hello
+	3,  // 5: ControlMessage.Register:type_name -> Register
+	4,  // 6: ControlMessage.Ready:type_name -> Ready
+	5,  // 7: ControlMessage.Rejected:type_name -> Rejected
+	6,  // 8: ControlMessage.Tunnel:type_name -> Tunnel
+	7,  // 9: ControlMessage.Heartbeat:type_name -> Heartbeat
+	8,  // 10: ControlMessage.TunnelData:type_name -> TunnelData
+	1,  // 11: ControlMessage.Service:type_name -> Service
+	9,  // 12: ControlServices.HandleMessage:input_type -> ControlMessage
+	9,  // 13: ControlServices.HandleMessage:output_type -> ControlMessage
+	13, // [13:14] is the sub-list for method output_type
+	12, // [12:13] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
 }
 
 func init() { file_message_proto_init() }
@@ -252,6 +1067,90 @@ func file_message_proto_init() {
 			}
 		}
 		file_message_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Hello); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Register); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Ready); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Rejected); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Tunnel); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Heartbeat); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TunnelData); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_message_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*ControlMessage); i {
 			case 0:
 				return &v.state
@@ -264,18 +1163,28 @@ func file_message_proto_init() {
 			}
 		}
 	}
+	file_message_proto_msgTypes[8].OneofWrappers = []interface{}{
+		(*ControlMessage_Hello)(nil),
+		(*ControlMessage_Register)(nil),
+		(*ControlMessage_Ready)(nil),
+		(*ControlMessage_Rejected)(nil),
+		(*ControlMessage_Tunnel)(nil),
+		(*ControlMessage_Heartbeat)(nil),
+		(*ControlMessage_TunnelData)(nil),
+	}
 	type x struct{}
 	out := protoimpl.TypeBuilder{
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_message_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   2,
+			NumEnums:      1,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
 		GoTypes:           file_message_proto_goTypes,
 		DependencyIndexes: file_message_proto_depIdxs,
+		EnumInfos:         file_message_proto_enumTypes,
 		MessageInfos:      file_message_proto_msgTypes,
 	}.Build()
 	File_message_proto = out.File
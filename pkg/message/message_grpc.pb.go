@@ -0,0 +1,115 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v3.21.11
+// source: message.proto
+
+package message
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	ControlServices_HandleMessage_FullMethodName = "/ControlServices/HandleMessage"
+)
+
+// ControlServicesClient is the client API for ControlServices service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ControlServicesClient interface {
+	HandleMessage(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ControlMessage, ControlMessage], error)
+}
+
+type controlServicesClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewControlServicesClient(cc grpc.ClientConnInterface) ControlServicesClient {
+	return &controlServicesClient{cc}
+}
+
+func (c *controlServicesClient) HandleMessage(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[ControlMessage, ControlMessage], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ControlServices_ServiceDesc.Streams[0], ControlServices_HandleMessage_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[ControlMessage, ControlMessage]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ControlServices_HandleMessageClient = grpc.BidiStreamingClient[ControlMessage, ControlMessage]
+
+// ControlServicesServer is the server API for ControlServices service.
+// All implementations must embed UnimplementedControlServicesServer
+// for forward compatibility.
+type ControlServicesServer interface {
+	HandleMessage(grpc.BidiStreamingServer[ControlMessage, ControlMessage]) error
+	mustEmbedUnimplementedControlServicesServer()
+}
+
+// UnimplementedControlServicesServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedControlServicesServer struct{}
+
+func (UnimplementedControlServicesServer) HandleMessage(grpc.BidiStreamingServer[ControlMessage, ControlMessage]) error {
+	return status.Errorf(codes.Unimplemented, "method HandleMessage not implemented")
+}
+func (UnimplementedControlServicesServer) mustEmbedUnimplementedControlServicesServer() {}
+func (UnimplementedControlServicesServer) testEmbeddedByValue()                         {}
+
+// UnsafeControlServicesServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ControlServicesServer will
+// result in compilation errors.
+type UnsafeControlServicesServer interface {
+	mustEmbedUnimplementedControlServicesServer()
+}
+
+func RegisterControlServicesServer(s grpc.ServiceRegistrar, srv ControlServicesServer) {
+	// If the following call pancis, it indicates UnimplementedControlServicesServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&ControlServices_ServiceDesc, srv)
+}
+
+func _ControlServices_HandleMessage_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(ControlServicesServer).HandleMessage(&grpc.GenericServerStream[ControlMessage, ControlMessage]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ControlServices_HandleMessageServer = grpc.BidiStreamingServer[ControlMessage, ControlMessage]
+
+// ControlServices_ServiceDesc is the grpc.ServiceDesc for ControlServices service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var ControlServices_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "ControlServices",
+	HandlerType: (*ControlServicesServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "HandleMessage",
+			Handler:       _ControlServices_HandleMessage_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "message.proto",
+}
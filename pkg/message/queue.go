@@ -0,0 +1,20 @@
+package message
+
+// UDP 服务队列满时的处理策略
+const (
+	// QueueDropNewest 丢弃新到的数据包
+	QueueDropNewest = "drop-newest"
+	// QueueDropOldest 丢弃队列中最早的数据包
+	QueueDropOldest = "drop-oldest"
+	// QueueBlock 等待队列有空位，会阻塞读取数据包的协程
+	QueueBlock = "block"
+)
+
+// IsQueuePolicySupported 检查队列策略是否支持，为空表示使用服务端配置
+func IsQueuePolicySupported(policy string) bool {
+	switch policy {
+	case "", QueueDropNewest, QueueDropOldest, QueueBlock:
+		return true
+	}
+	return false
+}
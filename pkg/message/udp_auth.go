@@ -0,0 +1,134 @@
+package message
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+const (
+	// SessionKeySize UDP 会话密钥长度
+	SessionKeySize = 32
+	// macSize 数据包签名长度，截断 HMAC-SHA256
+	macSize = 16
+	// replayWindowSize 防重放窗口大小，窗口内的乱序数据包可以接收
+	replayWindowSize = 64
+)
+
+// 数据包方向，参与签名，避免数据包被反射回发送方
+const (
+	directionClientToServer = 1
+	directionServerToClient = 2
+)
+
+var (
+	ErrPacketMAC    = errors.New("packet mac mismatch")
+	ErrPacketReplay = errors.New("packet replayed")
+)
+
+// NewSessionKey 生成 UDP 会话密钥，通过已鉴权的控制连接发送给客户端
+func NewSessionKey() ([]byte, error) {
+	key := make([]byte, SessionKeySize)
+	_, err := rand.Read(key)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// PacketAuth UDP 隧道数据包签名和校验，每个用户会话使用独立的会话密钥
+type PacketAuth struct {
+	key     []byte
+	sendDir byte
+	recvDir byte
+	// sendSeq 发送序号，从 1 开始
+	sendSeq atomic.Uint64
+	window  replayWindow
+}
+
+// NewPacketAuth server 为 true 时签名服务端发送的数据包，校验客户端发送的数据包
+func NewPacketAuth(key []byte, server bool) *PacketAuth {
+	a := &PacketAuth{key: key, sendDir: directionClientToServer, recvDir: directionServerToClient}
+	if server {
+		a.sendDir, a.recvDir = a.recvDir, a.sendDir
+	}
+	return a
+}
+
+// Sign 设置数据包序号和签名
+func (a *PacketAuth) Sign(msg *ControlMessage) {
+	msg.Seq = a.sendSeq.Add(1)
+	msg.MAC = a.mac(msg, a.sendDir)
+}
+
+// Verify 校验数据包签名和序号，重复或者过旧的数据包返回 ErrPacketReplay
+func (a *PacketAuth) Verify(msg *ControlMessage) error {
+	if !hmac.Equal(msg.GetMAC(), a.mac(msg, a.recvDir)) {
+		return ErrPacketMAC
+	}
+	if !a.window.check(msg.GetSeq()) {
+		return ErrPacketReplay
+	}
+	return nil
+}
+
+// mac 签名内容为方向、消息类型、服务 ID、会话 ID、序号、分片信息和数据
+func (a *PacketAuth) mac(msg *ControlMessage, dir byte) []byte {
+	sessionID := msg.GetTunnelData().GetSessionID()
+	if msg.GetCtl() == NewTunnel {
+		sessionID = msg.GetTunnel().GetSessionID()
+	}
+	h := hmac.New(sha256.New, a.key)
+	buf := []byte{dir}
+	buf = binary.BigEndian.AppendUint32(buf, uint32(msg.GetCtl()))
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(msg.GetServiceID())))
+	buf = append(buf, msg.GetServiceID()...)
+	buf = binary.BigEndian.AppendUint32(buf, uint32(len(sessionID)))
+	buf = append(buf, sessionID...)
+	buf = binary.BigEndian.AppendUint64(buf, msg.GetSeq())
+	// 未分片的数据包签名内容不变，兼容不支持分片的对端
+	if msg.GetTunnelData().GetFragCount() > 0 {
+		buf = binary.BigEndian.AppendUint32(buf, msg.GetTunnelData().GetFragID())
+		buf = binary.BigEndian.AppendUint32(buf, msg.GetTunnelData().GetFragIndex())
+		buf = binary.BigEndian.AppendUint32(buf, msg.GetTunnelData().GetFragCount())
+	}
+	h.Write(buf)
+	h.Write(msg.GetTunnelData().GetData())
+	return h.Sum(nil)[:macSize]
+}
+
+// replayWindow 滑动窗口防重放，记录最大序号和窗口内已接收的序号
+type replayWindow struct {
+	mx     sync.Mutex
+	max    uint64
+	bitmap uint64
+}
+
+func (w *replayWindow) check(seq uint64) bool {
+	if seq == 0 {
+		return false
+	}
+	w.mx.Lock()
+	defer w.mx.Unlock()
+	if seq > w.max {
+		shift := seq - w.max
+		if shift >= replayWindowSize {
+			w.bitmap = 0
+		} else {
+			w.bitmap <<= shift
+		}
+		w.bitmap |= 1
+		w.max = seq
+		return true
+	}
+	diff := w.max - seq
+	if diff >= replayWindowSize || w.bitmap&(1<<diff) != 0 {
+		return false
+	}
+	w.bitmap |= 1 << diff
+	return true
+}
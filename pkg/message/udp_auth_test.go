@@ -0,0 +1,76 @@
+package message
+
+import "testing"
+
+func TestReplayWindowCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		seqs []uint64
+		want []bool
+	}{
+		{"zero", []uint64{0}, []bool{false}},
+		{"increasing", []uint64{1, 2, 3}, []bool{true, true, true}},
+		{"duplicate", []uint64{1, 2, 2, 1}, []bool{true, true, false, false}},
+		{"out of order", []uint64{5, 3, 4, 3}, []bool{true, true, true, false}},
+		{"window edge", []uint64{replayWindowSize, 1}, []bool{true, true}},
+		{"too old", []uint64{replayWindowSize + 1, 1}, []bool{true, false}},
+		{"jump clears window", []uint64{1, 2, 1000, 999, 2, 1000}, []bool{true, true, true, true, false, false}},
+		{"shift keeps history", []uint64{10, 8, 20, 8, 9}, []bool{true, true, true, false, true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var w replayWindow
+			for i, seq := range tt.seqs {
+				if got := w.check(seq); got != tt.want[i] {
+					t.Fatalf("check(%d) at %d = %v, want %v", seq, i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestPacketAuth(t *testing.T) {
+	key, err := NewSessionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := NewPacketAuth(key, false), NewPacketAuth(key, true)
+	newMsg := func() *ControlMessage {
+		return &ControlMessage{
+			Ctl:       NewTunnelData,
+			ServiceID: "udp16150",
+			Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: "s1", Data: []byte("hello")}},
+		}
+	}
+	tests := []struct {
+		name    string
+		signer  *PacketAuth
+		tamper  func(msg *ControlMessage)
+		wantErr bool
+	}{
+		{"valid", client, func(msg *ControlMessage) {}, false},
+		{"tampered data", client, func(msg *ControlMessage) { msg.GetTunnelData().Data = []byte("hellp") }, true},
+		{"tampered session", client, func(msg *ControlMessage) { msg.GetTunnelData().SessionID = "s2" }, true},
+		{"reflected", server, func(msg *ControlMessage) {}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := newMsg()
+			tt.signer.Sign(msg)
+			tt.tamper(msg)
+			if err := server.Verify(msg); (err != nil) != tt.wantErr {
+				t.Fatalf("Verify() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+	t.Run("replay", func(t *testing.T) {
+		msg := newMsg()
+		client.Sign(msg)
+		if err := server.Verify(msg); err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		if err := server.Verify(msg); err == nil {
+			t.Fatal("Verify() replayed message, want error")
+		}
+	})
+}
@@ -0,0 +1,183 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"net"
+	"sync"
+)
+
+const (
+	// compactMagic 紧凑格式数据包的首字节，protobuf 消息的首字节是字段标签，不会是 0
+	compactMagic = 0x00
+	// compactVersion 紧凑格式版本
+	compactVersion = 1
+	// compactFlagAuth 数据包带序号和签名
+	compactFlagAuth = 1 << 0
+	// compactFlagFragment 数据包是分片
+	compactFlagFragment = 1 << 1
+)
+
+var errCompactData = errors.New("invalid compact tunnel data")
+
+// udpBufPool UDP 数据包缓冲区，读取时反序列化会复制数据，缓冲区可以复用
+var udpBufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, MaxUDPDataSize)
+		return &buf
+	},
+}
+
+// GetUDPBuf 从缓冲池获取 UDP 数据包缓冲区，长度为 MaxUDPDataSize
+func GetUDPBuf() *[]byte {
+	return udpBufPool.Get().(*[]byte)
+}
+
+// PutUDPBuf 把缓冲区放回缓冲池，调用方不能再使用该缓冲区
+func PutUDPBuf(buf *[]byte) {
+	*buf = (*buf)[:cap(*buf)]
+	udpBufPool.Put(buf)
+}
+
+// AppendUDP 序列化 UDP 隧道消息追加到 buf，compact 为 true 时隧道数据使用紧凑格式，其他消息使用 protobuf
+func AppendUDP(buf []byte, msg *ControlMessage, compact bool) ([]byte, error) {
+	if compact && msg.GetCtl() == NewTunnelData && msg.GetTunnelData() != nil {
+		return appendCompact(buf, msg)
+	}
+	return proto.MarshalOptions{}.MarshalAppend(buf, msg)
+}
+
+// UnmarshalUDP 反序列化 UDP 隧道消息，自动识别紧凑格式和 protobuf，返回的消息不引用 b
+func UnmarshalUDP(b []byte) (*ControlMessage, error) {
+	if len(b) > 0 && b[0] == compactMagic {
+		return decodeCompact(b)
+	}
+	return Unmarshal(b)
+}
+
+// CompactSessionID 返回紧凑格式数据包的会话 ID，不复制数据，不是紧凑格式时返回 nil
+func CompactSessionID(b []byte) []byte {
+	if len(b) < 4 || b[0] != compactMagic || b[1] != compactVersion {
+		return nil
+	}
+	i := 4 + int(b[3])
+	if len(b) < i+1 || len(b) < i+1+int(b[i]) {
+		return nil
+	}
+	return b[i+1 : i+1+int(b[i])]
+}
+
+// appendCompact 紧凑格式：magic(1) version(1) flags(1) serviceID 长度(1) serviceID sessionID 长度(1) sessionID
+// 有签名时接 seq(8) mac(16)，是分片时接 fragID(4) fragIndex(2) fragCount(2)，最后是数据
+func appendCompact(buf []byte, msg *ControlMessage) ([]byte, error) {
+	data := msg.GetTunnelData()
+	if len(msg.GetServiceID()) > 255 || len(data.GetSessionID()) > 255 {
+		return nil, errCompactData
+	}
+	var flags byte
+	if len(msg.GetMAC()) > 0 {
+		if len(msg.GetMAC()) != macSize {
+			return nil, errCompactData
+		}
+		flags |= compactFlagAuth
+	}
+	if data.GetFragCount() > 0 {
+		flags |= compactFlagFragment
+	}
+	buf = append(buf, compactMagic, compactVersion, flags, byte(len(msg.GetServiceID())))
+	buf = append(buf, msg.GetServiceID()...)
+	buf = append(buf, byte(len(data.GetSessionID())))
+	buf = append(buf, data.GetSessionID()...)
+	if flags&compactFlagAuth != 0 {
+		buf = binary.BigEndian.AppendUint64(buf, msg.GetSeq())
+		buf = append(buf, msg.GetMAC()...)
+	}
+	if flags&compactFlagFragment != 0 {
+		buf = binary.BigEndian.AppendUint32(buf, data.GetFragID())
+		buf = binary.BigEndian.AppendUint16(buf, uint16(data.GetFragIndex()))
+		buf = binary.BigEndian.AppendUint16(buf, uint16(data.GetFragCount()))
+	}
+	return append(buf, data.GetData()...), nil
+}
+
+func decodeCompact(b []byte) (*ControlMessage, error) {
+	if len(b) < 4 || b[1] != compactVersion {
+		return nil, errCompactData
+	}
+	flags := b[2]
+	b = b[3:]
+	serviceID, b, ok := readCompactString(b)
+	if !ok {
+		return nil, errCompactData
+	}
+	sessionID, b, ok := readCompactString(b)
+	if !ok {
+		return nil, errCompactData
+	}
+	data := &TunnelData{SessionID: sessionID}
+	msg := &ControlMessage{
+		Ctl:       NewTunnelData,
+		ServiceID: serviceID,
+		Payload:   &ControlMessage_TunnelData{TunnelData: data},
+	}
+	if flags&compactFlagAuth != 0 {
+		if len(b) < 8+macSize {
+			return nil, errCompactData
+		}
+		msg.Seq = binary.BigEndian.Uint64(b)
+		msg.MAC = append([]byte(nil), b[8:8+macSize]...)
+		b = b[8+macSize:]
+	}
+	if flags&compactFlagFragment != 0 {
+		if len(b) < 8 {
+			return nil, errCompactData
+		}
+		data.FragID = binary.BigEndian.Uint32(b)
+		data.FragIndex = uint32(binary.BigEndian.Uint16(b[4:]))
+		data.FragCount = uint32(binary.BigEndian.Uint16(b[6:]))
+		b = b[8:]
+	}
+	data.Data = append([]byte(nil), b...)
+	return msg, nil
+}
+
+func readCompactString(b []byte) (string, []byte, bool) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return "", nil, false
+	}
+	return string(b[1 : 1+int(b[0])]), b[1+int(b[0]):], true
+}
+
+// WriteUDP 发送 UDP 隧道消息，compact 为 true 时隧道数据使用紧凑格式
+func WriteUDP(msg *ControlMessage, conn net.Conn, compact bool) error {
+	buf := GetUDPBuf()
+	defer PutUDPBuf(buf)
+	bp, err := AppendUDP((*buf)[:0], msg, compact)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(bp)
+	return err
+}
+
+func WriteToUDP(msg *ControlMessage, conn net.PacketConn, remoteAddr net.Addr, compact bool) error {
+	buf := GetUDPBuf()
+	defer PutUDPBuf(buf)
+	bp, err := AppendUDP((*buf)[:0], msg, compact)
+	if err != nil {
+		return err
+	}
+	_, err = conn.WriteTo(bp, remoteAddr)
+	return err
+}
+
+func ReadUDP(conn net.Conn) (*ControlMessage, error) {
+	buf := GetUDPBuf()
+	defer PutUDPBuf(buf)
+	n, err := conn.Read(*buf)
+	if err != nil {
+		return nil, err
+	}
+	return UnmarshalUDP((*buf)[:n])
+}
@@ -0,0 +1,195 @@
+package message
+
+import (
+	"bytes"
+	"google.golang.org/protobuf/proto"
+	"strings"
+	"testing"
+)
+
+func TestCompactRoundTrip(t *testing.T) {
+	mac := bytes.Repeat([]byte{0xab}, macSize)
+	tests := []struct {
+		name string
+		msg  *ControlMessage
+	}{
+		{"plain", newTunnelData(100)},
+		{"empty data", newTunnelData(0)},
+		{"auth", &ControlMessage{
+			Ctl:       NewTunnelData,
+			ServiceID: "udp16150",
+			Seq:       1<<63 + 5,
+			MAC:       mac,
+			Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: "s1", Data: []byte("hello")}},
+		}},
+		{"fragment", &ControlMessage{
+			Ctl:       NewTunnelData,
+			ServiceID: "udp16150",
+			Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: "s1", Data: []byte("hello"), FragID: 1 << 31, FragIndex: 255, FragCount: 256}},
+		}},
+		{"auth fragment", &ControlMessage{
+			Ctl:       NewTunnelData,
+			ServiceID: "udp16150",
+			Seq:       7,
+			MAC:       mac,
+			Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: "s1", Data: []byte("hello"), FragID: 3, FragIndex: 1, FragCount: 2}},
+		}},
+		{"max id length", &ControlMessage{
+			Ctl:       NewTunnelData,
+			ServiceID: strings.Repeat("s", 255),
+			Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: strings.Repeat("i", 255), Data: []byte("hello")}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := appendCompact([]byte("prefix"), tt.msg)
+			if err != nil {
+				t.Fatalf("appendCompact() error = %v", err)
+			}
+			if !bytes.HasPrefix(b, []byte("prefix")) {
+				t.Fatal("appendCompact() should append to buf")
+			}
+			b = b[len("prefix"):]
+			if got := CompactSessionID(b); string(got) != tt.msg.GetTunnelData().GetSessionID() {
+				t.Fatalf("CompactSessionID() = %q", got)
+			}
+			got, err := UnmarshalUDP(b)
+			if err != nil {
+				t.Fatalf("UnmarshalUDP() error = %v", err)
+			}
+			if !proto.Equal(got, tt.msg) {
+				t.Fatalf("UnmarshalUDP() = %v, want %v", got, tt.msg)
+			}
+			// 反序列化的消息不能引用读取缓冲区
+			for i := range b {
+				b[i] = 0xff
+			}
+			if !proto.Equal(got, tt.msg) {
+				t.Fatal("decoded message references input buffer")
+			}
+		})
+	}
+}
+
+func TestAppendCompactError(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *ControlMessage
+	}{
+		{"long service id", &ControlMessage{
+			Ctl:       NewTunnelData,
+			ServiceID: strings.Repeat("s", 256),
+			Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: "s1"}},
+		}},
+		{"long session id", &ControlMessage{
+			Ctl:       NewTunnelData,
+			ServiceID: "udp16150",
+			Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: strings.Repeat("i", 256)}},
+		}},
+		{"invalid mac size", &ControlMessage{
+			Ctl:       NewTunnelData,
+			ServiceID: "udp16150",
+			MAC:       []byte{1, 2, 3},
+			Payload:   &ControlMessage_TunnelData{TunnelData: &TunnelData{SessionID: "s1"}},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := appendCompact(nil, tt.msg); err == nil {
+				t.Fatal("appendCompact() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestDecodeCompactError(t *testing.T) {
+	msg := newTunnelData(10)
+	msg.Seq = 1
+	msg.MAC = make([]byte, macSize)
+	msg.GetTunnelData().FragCount = 2
+	b, err := appendCompact(nil, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 数据之前的部分被截断时都不能解析
+	header := len(b) - 10
+	for i := 0; i < header; i++ {
+		if _, err := decodeCompact(b[:i]); err == nil {
+			t.Fatalf("decodeCompact() truncated at %d, want error", i)
+		}
+	}
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"version", []byte{compactMagic, compactVersion + 1, 0, 0, 0}},
+		{"service id length", []byte{compactMagic, compactVersion, 0, 5, 's'}},
+		{"session id length", []byte{compactMagic, compactVersion, 0, 1, 's', 5, 'i'}},
+		{"auth", []byte{compactMagic, compactVersion, compactFlagAuth, 1, 's', 1, 'i', 0, 0}},
+		{"fragment", []byte{compactMagic, compactVersion, compactFlagFragment, 1, 's', 1, 'i', 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := decodeCompact(tt.b); err == nil {
+				t.Fatal("decodeCompact() error = nil, want error")
+			}
+		})
+	}
+}
+
+func TestAppendUDP(t *testing.T) {
+	tests := []struct {
+		name        string
+		msg         *ControlMessage
+		compact     bool
+		wantCompact bool
+	}{
+		{"compact tunnel data", newTunnelData(10), true, true},
+		{"protobuf tunnel data", newTunnelData(10), false, false},
+		{"compact new tunnel", &ControlMessage{
+			Ctl:       NewTunnel,
+			ServiceID: "udp16150",
+			Payload:   &ControlMessage_Tunnel{Tunnel: &Tunnel{SessionID: "s1"}},
+		}, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := AppendUDP(nil, tt.msg, tt.compact)
+			if err != nil {
+				t.Fatalf("AppendUDP() error = %v", err)
+			}
+			if got := b[0] == compactMagic; got != tt.wantCompact {
+				t.Fatalf("AppendUDP() compact = %v, want %v", got, tt.wantCompact)
+			}
+			got, err := UnmarshalUDP(b)
+			if err != nil {
+				t.Fatalf("UnmarshalUDP() error = %v", err)
+			}
+			if !proto.Equal(got, tt.msg) {
+				t.Fatalf("UnmarshalUDP() = %v, want %v", got, tt.msg)
+			}
+		})
+	}
+}
+
+func TestCompactPacketAuth(t *testing.T) {
+	key, err := NewSessionKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, server := NewPacketAuth(key, false), NewPacketAuth(key, true)
+	for _, frag := range Fragment(newTunnelData(3000), 1200, 1) {
+		client.Sign(frag)
+		b, err := AppendUDP(nil, frag, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := UnmarshalUDP(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := server.Verify(got); err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+	}
+}
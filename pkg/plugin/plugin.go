@@ -0,0 +1,182 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"gnp/pkg/config"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+)
+
+const (
+	// Login 客户端建立控制连接，可以修改 user
+	Login = "login"
+	// Register 客户端注册代理服务，可以修改 proxy_port、conn_timeout、udp_queue_size 和 udp_queue_policy
+	Register = "register"
+	// NewSession 用户会话和隧道配对，可以修改 conn_timeout
+	NewSession = "new_session"
+)
+
+// defaultTimeout 插件没有设置超时时间时使用，需要在客户端等待握手响应超时前返回
+const defaultTimeout = time.Second * 2
+
+// Request 发送给插件服务的请求
+type Request struct {
+	Op      string `json:"op"`
+	Content any    `json:"content"`
+}
+
+// Response 插件服务的响应，reject 为 true 时拒绝，unchange 为 false 时使用 content 修改请求
+type Response struct {
+	Reject       bool            `json:"reject"`
+	RejectReason string          `json:"reject_reason"`
+	Unchange     bool            `json:"unchange"`
+	Content      json.RawMessage `json:"content"`
+}
+
+type LoginContent struct {
+	// Addr 客户端地址
+	Addr string `json:"addr"`
+	// User 客户端声明的用户名，插件修改后用于之后的插件请求
+	User     string `json:"user"`
+	ClientID string `json:"client_id"`
+	// Version 客户端版本，不支持握手的旧版本客户端为空
+	Version string `json:"version"`
+}
+
+type RegisterContent struct {
+	Addr           string `json:"addr"`
+	User           string `json:"user"`
+	ClientID       string `json:"client_id"`
+	ServiceID      string `json:"service_id"`
+	Network        string `json:"network"`
+	ProxyPort      string `json:"proxy_port"`
+	ConnTimeout    uint32 `json:"conn_timeout"`
+	UDPQueueSize   uint32 `json:"udp_queue_size"`
+	UDPQueuePolicy string `json:"udp_queue_policy"`
+}
+
+type NewSessionContent struct {
+	User      string `json:"user"`
+	ClientID  string `json:"client_id"`
+	ServiceID string `json:"service_id"`
+	Network   string `json:"network"`
+	ProxyPort string `json:"proxy_port"`
+	SessionID string `json:"session_id"`
+	// RemoteAddr 用户地址
+	RemoteAddr string `json:"remote_addr"`
+	// ConnTimeout 会话空闲超时时间，单位秒
+	ConnTimeout int `json:"conn_timeout"`
+}
+
+// Plugin 一个插件服务
+type Plugin struct {
+	conf   config.Plugin
+	client *http.Client
+}
+
+// Manager 按配置顺序调用插件，前一个插件修改后的请求发送给下一个插件
+type Manager struct {
+	plugins []*Plugin
+}
+
+// NewManager 按配置创建插件，配置为空时不调用插件
+func NewManager(confs []config.Plugin) (*Manager, error) {
+	m := &Manager{}
+	for _, conf := range confs {
+		if conf.URL == "" {
+			return nil, fmt.Errorf("plugin %s url is empty", conf.Name)
+		}
+		if conf.Timeout < 0 {
+			return nil, fmt.Errorf("plugin %s timeout must not be less than 0", conf.Name)
+		}
+		for _, op := range conf.Ops {
+			if op != Login && op != Register && op != NewSession {
+				return nil, fmt.Errorf("plugin %s unsupported op %s", conf.Name, op)
+			}
+		}
+		timeout := defaultTimeout
+		if conf.Timeout > 0 {
+			timeout = time.Second * time.Duration(conf.Timeout)
+		}
+		m.plugins = append(m.plugins, &Plugin{conf: conf, client: &http.Client{Timeout: timeout}})
+	}
+	return m, nil
+}
+
+// Has 是否有插件处理该操作，没有时不需要准备请求内容
+func (m *Manager) Has(op string) bool {
+	for _, p := range m.plugins {
+		if slices.Contains(p.conf.Ops, op) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle 调用处理该操作的插件，content 为请求内容的指针，插件修改后写回 content，
+// 拒绝时返回的错误作为原因发送给客户端，请求失败时按插件的 fail_open 配置放行或者拒绝
+func (m *Manager) Handle(ctx context.Context, op string, content any) error {
+	for _, p := range m.plugins {
+		if !slices.Contains(p.conf.Ops, op) {
+			continue
+		}
+		res, err := p.send(ctx, op, content)
+		if err != nil {
+			if p.conf.FailOpen {
+				logrus.Warnf("plugin %s %s %v, fail open", p.conf.Name, op, err)
+				continue
+			}
+			logrus.Errorf("plugin %s %s %v", p.conf.Name, op, err)
+			return fmt.Errorf("plugin %s unavailable", p.conf.Name)
+		}
+		if res.Reject {
+			if res.RejectReason == "" {
+				return fmt.Errorf("rejected by plugin %s", p.conf.Name)
+			}
+			return errors.New(res.RejectReason)
+		}
+		if !res.Unchange && len(res.Content) > 0 {
+			err = json.Unmarshal(res.Content, content)
+			if err != nil {
+				logrus.Errorf("plugin %s %s content %v", p.conf.Name, op, err)
+				return fmt.Errorf("plugin %s unavailable", p.conf.Name)
+			}
+		}
+	}
+	return nil
+}
+
+func (p *Plugin) send(ctx context.Context, op string, content any) (*Response, error) {
+	body, err := json.Marshal(&Request{Op: op, Content: content})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = resp.Body.Close()
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	var res Response
+	err = json.NewDecoder(io.LimitReader(resp.Body, 1<<16)).Decode(&res)
+	if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
@@ -0,0 +1,55 @@
+package transport
+
+import (
+	"golang.org/x/net/ipv4"
+	"net"
+)
+
+// Message 批量读写的 UDP 数据包，Buffers 只使用第一个缓冲区
+type Message = ipv4.Message
+
+// BatchConn 批量读写 UDP 数据包，Linux 上的 UDP socket 使用 recvmmsg 和 sendmmsg，其他情况逐个读写
+type BatchConn interface {
+	// ReadBatch 读取至少一个数据包，返回读取的数量
+	ReadBatch(msgs []Message) (int, error)
+	// WriteBatch 发送数据包，返回发送的数量
+	WriteBatch(msgs []Message) (int, error)
+}
+
+// WriteBatch 发送全部数据包，批量发送只发送了部分数据包时继续发送剩余的
+func WriteBatch(conn BatchConn, msgs []Message) error {
+	for len(msgs) > 0 {
+		n, err := conn.WriteBatch(msgs)
+		if err != nil {
+			return err
+		}
+		msgs = msgs[n:]
+	}
+	return nil
+}
+
+// singleConn 逐个读写数据包
+type singleConn struct {
+	net.PacketConn
+}
+
+func (c *singleConn) ReadBatch(msgs []Message) (int, error) {
+	n, addr, err := c.ReadFrom(msgs[0].Buffers[0])
+	if err != nil {
+		return 0, err
+	}
+	msgs[0].N = n
+	msgs[0].Addr = addr
+	return 1, nil
+}
+
+func (c *singleConn) WriteBatch(msgs []Message) (int, error) {
+	for i := range msgs {
+		n, err := c.WriteTo(msgs[i].Buffers[0], msgs[i].Addr)
+		if err != nil {
+			return i, err
+		}
+		msgs[i].N = n
+	}
+	return len(msgs), nil
+}
@@ -0,0 +1,39 @@
+//go:build linux
+
+package transport
+
+import (
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"net"
+)
+
+// batchPacketConn ipv4.PacketConn 和 ipv6.PacketConn 共有的批量读写方法
+type batchPacketConn interface {
+	ReadBatch(msgs []Message, flags int) (int, error)
+	WriteBatch(msgs []Message, flags int) (int, error)
+}
+
+type mmsgConn struct {
+	conn batchPacketConn
+}
+
+// NewBatchConn 创建批量读写连接，只有标准库的 UDP socket 支持 recvmmsg 和 sendmmsg
+func NewBatchConn(conn net.PacketConn) BatchConn {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return &singleConn{PacketConn: conn}
+	}
+	if addr, ok := udpConn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() != nil {
+		return &mmsgConn{conn: ipv4.NewPacketConn(udpConn)}
+	}
+	return &mmsgConn{conn: ipv6.NewPacketConn(udpConn)}
+}
+
+func (c *mmsgConn) ReadBatch(msgs []Message) (int, error) {
+	return c.conn.ReadBatch(msgs, 0)
+}
+
+func (c *mmsgConn) WriteBatch(msgs []Message) (int, error) {
+	return c.conn.WriteBatch(msgs, 0)
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package transport
+
+import "net"
+
+// NewBatchConn 创建批量读写连接，非 Linux 系统逐个读写
+func NewBatchConn(conn net.PacketConn) BatchConn {
+	return &singleConn{PacketConn: conn}
+}
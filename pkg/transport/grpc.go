@@ -0,0 +1,152 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"gnp/pkg/message"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/peer"
+	"net"
+	"sync"
+	"time"
+)
+
+// grpcCloseTimeout 客户端关闭流后等待服务端结束流的时间，避免未发送完的数据被丢弃
+const grpcCloseTimeout = time.Second * 5
+
+// grpcDialer 每个服务端地址复用一个 HTTP/2 连接，每个控制连接和隧道连接对应一个双向流
+type grpcDialer struct {
+	udpDialer
+	mx    sync.Mutex
+	conns map[string]*grpc.ClientConn
+	creds credentials.TransportCredentials
+	dial  dialFunc
+}
+
+func newGRPCDialer(tlsConf *tls.Config, dial dialFunc) *grpcDialer {
+	creds := insecure.NewCredentials()
+	if tlsConf != nil {
+		creds = credentials.NewTLS(tlsConf)
+	}
+	return &grpcDialer{
+		conns: make(map[string]*grpc.ClientConn),
+		creds: creds,
+		dial:  dial,
+	}
+}
+
+func (d *grpcDialer) clientConn(addr string) (*grpc.ClientConn, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if cc, ok := d.conns[addr]; ok {
+		return cc, nil
+	}
+	target := addr
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(d.creds)}
+	if d.dial != nil {
+		// 服务端地址原样交给代理解析
+		target = "passthrough:///" + addr
+		opts = append(opts, grpc.WithContextDialer(d.dial))
+	}
+	cc, err := grpc.NewClient(target, opts...)
+	if err != nil {
+		return nil, err
+	}
+	d.conns[addr] = cc
+	return cc, nil
+}
+
+func (d *grpcDialer) Dial(addr string) (net.Conn, error) {
+	cc, err := d.clientConn(addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := message.NewControlServicesClient(cc).HandleMessage(ctx)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	var conn *streamConn
+	conn = newStreamConn(stream, streamAddr{network: GRPC}, streamAddr{network: GRPC, addr: addr}, func() {
+		_ = stream.CloseSend()
+		go func() {
+			select {
+			case <-conn.readDone:
+			case <-time.After(grpcCloseTimeout):
+			}
+			cancel()
+		}()
+	})
+	return conn, nil
+}
+
+func (d *grpcDialer) Reset(addr string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if cc, ok := d.conns[addr]; ok {
+		_ = cc.Close()
+		delete(d.conns, addr)
+	}
+}
+
+func (d *grpcDialer) Close() error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	for addr, cc := range d.conns {
+		_ = cc.Close()
+		delete(d.conns, addr)
+	}
+	return nil
+}
+
+// grpcListener 实现 ControlServices，把每个 HandleMessage 流作为一个连接返回给 Accept
+type grpcListener struct {
+	message.UnimplementedControlServicesServer
+	*connListener
+	server *grpc.Server
+}
+
+func listenGRPC(addr string, tlsConf *tls.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	var opts []grpc.ServerOption
+	if tlsConf != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConf)))
+	}
+	l := &grpcListener{
+		connListener: newConnListener(listener.Addr()),
+		server:       grpc.NewServer(opts...),
+	}
+	l.onClose = l.server.Stop
+	message.RegisterControlServicesServer(l.server, l)
+	go func() {
+		_ = l.server.Serve(listener)
+	}()
+	return l, nil
+}
+
+func (l *grpcListener) HandleMessage(stream message.ControlServices_HandleMessageServer) error {
+	var remoteAddr net.Addr = streamAddr{network: GRPC}
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		remoteAddr = p.Addr
+	}
+	done := make(chan struct{})
+	conn := newStreamConn(stream, l.Addr(), remoteAddr, func() {
+		close(done)
+	})
+	if !l.push(stream.Context().Done(), conn) {
+		return net.ErrClosed
+	}
+	// 返回后流结束，连接关闭或者客户端取消前保持
+	select {
+	case <-done:
+	case <-stream.Context().Done():
+		_ = conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,307 @@
+package transport
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"github.com/xtaci/kcp-go/v5"
+	"github.com/xtaci/smux"
+	"gnp/pkg/config"
+	"gnp/pkg/message"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// kcpPacketPrefix KCP 数据包前缀，用于和 UDP 隧道数据共用服务端 UDP 端口，隧道数据以 protobuf 字段标签 0x08 或者紧凑格式的 0x00 开头
+	kcpPacketPrefix = 0xf1
+	// kcpPacketQueueSize 服务端分发数据包的队列长度，队列满时和 UDP 一样丢弃
+	kcpPacketQueueSize = 1024
+)
+
+func smuxConfig() *smux.Config {
+	conf := smux.DefaultConfig()
+	conf.KeepAliveInterval = time.Second * 5
+	conf.KeepAliveTimeout = time.Second * 15
+	return conf
+}
+
+// setKCP 设置 KCP 会话参数，数据包前缀占用一个字节
+func setKCP(sess *kcp.UDPSession, conf *config.KCP) {
+	sess.SetStreamMode(true)
+	sess.SetWriteDelay(false)
+	sess.SetNoDelay(conf.NoDelay, conf.Interval, conf.Resend, conf.NoCongestion)
+	sess.SetWindowSize(conf.SndWnd, conf.RcvWnd)
+	sess.SetMtu(conf.MTU - 1)
+}
+
+// kcpDialer 每个服务端地址使用一个 KCP 会话，控制连接和 TCP 隧道连接是会话上的 smux 流
+type kcpDialer struct {
+	udpDialer
+	conf     config.KCP
+	mx       sync.Mutex
+	sessions map[string]*smux.Session
+}
+
+func newKCPDialer(conf config.KCP) *kcpDialer {
+	return &kcpDialer{
+		conf:     conf,
+		sessions: make(map[string]*smux.Session),
+	}
+}
+
+func (d *kcpDialer) session(addr string) (*smux.Session, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if s, ok := d.sessions[addr]; ok && !s.IsClosed() {
+		return s, nil
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	var conv uint32
+	_ = binary.Read(rand.Reader, binary.LittleEndian, &conv)
+	sess, err := kcp.NewConn3(conv, raddr, nil, 0, 0, &prefixPacketConn{PacketConn: udpConn})
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, err
+	}
+	setKCP(sess, &d.conf)
+	s, err := smux.Client(&kcpConn{UDPSession: sess, conn: udpConn}, smuxConfig())
+	if err != nil {
+		_ = sess.Close()
+		_ = udpConn.Close()
+		return nil, err
+	}
+	d.sessions[addr] = s
+	return s, nil
+}
+
+func (d *kcpDialer) Dial(addr string) (net.Conn, error) {
+	s, err := d.session(addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.OpenStream()
+}
+
+func (d *kcpDialer) Reset(addr string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if s, ok := d.sessions[addr]; ok {
+		_ = s.Close()
+		delete(d.sessions, addr)
+	}
+}
+
+func (d *kcpDialer) Close() error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	for addr, s := range d.sessions {
+		_ = s.Close()
+		delete(d.sessions, addr)
+	}
+	return nil
+}
+
+// kcpConn 客户端 KCP 会话，关闭时同时关闭 UDP 连接
+type kcpConn struct {
+	*kcp.UDPSession
+	conn net.PacketConn
+}
+
+func (c *kcpConn) Close() error {
+	_ = c.UDPSession.Close()
+	return c.conn.Close()
+}
+
+// prefixPacketConn 客户端发送的 KCP 数据包加上前缀，接收时去掉前缀
+type prefixPacketConn struct {
+	net.PacketConn
+}
+
+func (c *prefixPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	buf := make([]byte, len(b)+1)
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(buf)
+		if err != nil {
+			return 0, addr, err
+		}
+		if n > 0 && buf[0] == kcpPacketPrefix {
+			return copy(b, buf[1:n]), addr, nil
+		}
+	}
+}
+
+func (c *prefixPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	buf := make([]byte, len(b)+1)
+	buf[0] = kcpPacketPrefix
+	copy(buf[1:], b)
+	n, err := c.PacketConn.WriteTo(buf, addr)
+	if n > 0 {
+		n--
+	}
+	return n, err
+}
+
+// listenKCP 在服务端 UDP 端口上按前缀分发 KCP 数据包和 UDP 隧道数据
+func listenKCP(addr string, conf config.KCP) (net.Listener, net.PacketConn, error) {
+	udpConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	kcpPackets := newDemuxPacketConn(udpConn, []byte{kcpPacketPrefix})
+	tunnelPackets := newDemuxPacketConn(udpConn, nil)
+	tunnelPackets.onClose = func() {
+		_ = udpConn.Close()
+	}
+	go demux(udpConn, kcpPackets, tunnelPackets)
+	ln, err := kcp.ServeConn(nil, 0, 0, kcpPackets)
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, nil, err
+	}
+	streams := newConnListener(ln.Addr())
+	streams.onClose = func() {
+		_ = ln.Close()
+		_ = kcpPackets.Close()
+	}
+	go func() {
+		for {
+			sess, err := ln.AcceptKCP()
+			if err != nil {
+				return
+			}
+			setKCP(sess, &conf)
+			s, err := smux.Server(sess, smuxConfig())
+			if err != nil {
+				_ = sess.Close()
+				continue
+			}
+			go acceptSmuxStreams(s, streams)
+		}
+	}()
+	return streams, tunnelPackets, nil
+}
+
+func acceptSmuxStreams(s *smux.Session, streams *connListener) {
+	defer func() {
+		_ = s.Close()
+	}()
+	for {
+		stream, err := s.AcceptStream()
+		if err != nil {
+			return
+		}
+		if !streams.push(s.CloseChan(), stream) {
+			_ = stream.Close()
+			return
+		}
+	}
+}
+
+// demux 读取服务端 UDP 端口的数据包，带 KCP 前缀的交给 KCP，其他的作为 UDP 隧道数据
+func demux(conn net.PacketConn, kcpPackets, tunnelPackets *demuxPacketConn) {
+	defer func() {
+		_ = kcpPackets.Close()
+		_ = tunnelPackets.Close()
+	}()
+	buf := message.GetUDPBuf()
+	defer message.PutUDPBuf(buf)
+	for {
+		n, addr, err := conn.ReadFrom(*buf)
+		if err != nil {
+			return
+		}
+		// 队列中的数据包在读取前一直保留，复制后缓冲区可以复用
+		if n > 0 && (*buf)[0] == kcpPacketPrefix {
+			kcpPackets.deliver(append([]byte(nil), (*buf)[1:n]...), addr)
+			continue
+		}
+		tunnelPackets.deliver(append([]byte(nil), (*buf)[:n]...), addr)
+	}
+}
+
+// demuxPacketConn 从分发队列读取数据包，写入时加上前缀后通过 UDP 端口发送
+type demuxPacketConn struct {
+	conn         net.PacketConn
+	prefix       []byte
+	packetCh     chan packet
+	readDeadline *deadline
+	closeCh      chan struct{}
+	onceClose    sync.Once
+	onClose      func()
+}
+
+func newDemuxPacketConn(conn net.PacketConn, prefix []byte) *demuxPacketConn {
+	return &demuxPacketConn{
+		conn:         conn,
+		prefix:       prefix,
+		packetCh:     make(chan packet, kcpPacketQueueSize),
+		readDeadline: newDeadline(),
+		closeCh:      make(chan struct{}),
+	}
+}
+
+func (p *demuxPacketConn) deliver(data []byte, addr net.Addr) {
+	select {
+	case p.packetCh <- packet{data: data, addr: addr}:
+	default:
+	}
+}
+
+func (p *demuxPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case pkt := <-p.packetCh:
+		return copy(b, pkt.data), pkt.addr, nil
+	case <-p.closeCh:
+		return 0, nil, net.ErrClosed
+	case <-p.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (p *demuxPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(p.prefix) == 0 {
+		return p.conn.WriteTo(b, addr)
+	}
+	buf := make([]byte, len(p.prefix)+len(b))
+	copy(buf, p.prefix)
+	copy(buf[len(p.prefix):], b)
+	n, err := p.conn.WriteTo(buf, addr)
+	return max(n-len(p.prefix), 0), err
+}
+
+func (p *demuxPacketConn) Close() error {
+	p.onceClose.Do(func() {
+		close(p.closeCh)
+		if p.onClose != nil {
+			p.onClose()
+		}
+	})
+	return nil
+}
+
+func (p *demuxPacketConn) LocalAddr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+func (p *demuxPacketConn) SetDeadline(t time.Time) error {
+	p.readDeadline.set(t)
+	return nil
+}
+
+func (p *demuxPacketConn) SetReadDeadline(t time.Time) error {
+	p.readDeadline.set(t)
+	return nil
+}
+
+func (p *demuxPacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
@@ -0,0 +1,59 @@
+package transport
+
+import (
+	"net"
+	"sync"
+)
+
+// connListener 把 HTTP 请求或者多路复用连接中建立的连接通过 Accept 返回
+type connListener struct {
+	addr      net.Addr
+	connCh    chan net.Conn
+	closeCh   chan struct{}
+	onceClose sync.Once
+	// onClose 关闭监听时停止 HTTP 服务
+	onClose func()
+}
+
+func newConnListener(addr net.Addr) *connListener {
+	return &connListener{
+		addr:    addr,
+		connCh:  make(chan net.Conn),
+		closeCh: make(chan struct{}),
+	}
+}
+
+// push 等待 Accept 取走连接，监听关闭或者 done 关闭时返回 false
+func (l *connListener) push(done <-chan struct{}, conn net.Conn) bool {
+	select {
+	case l.connCh <- conn:
+		return true
+	case <-l.closeCh:
+		return false
+	case <-done:
+		return false
+	}
+}
+
+func (l *connListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *connListener) Close() error {
+	l.onceClose.Do(func() {
+		close(l.closeCh)
+		if l.onClose != nil {
+			l.onClose()
+		}
+	})
+	return nil
+}
+
+func (l *connListener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,129 @@
+package transport
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"gnp/pkg/config"
+	"golang.org/x/net/proxy"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// 上游代理类型
+const (
+	ProxyHTTP   = "http"
+	ProxySOCKS5 = "socks5"
+)
+
+// proxyDialTimeout 通过代理建立连接的超时时间
+const proxyDialTimeout = time.Second * 10
+
+// dialFunc 建立到服务端的 TCP 连接
+type dialFunc func(ctx context.Context, addr string) (net.Conn, error)
+
+// CheckProxy 检查上游代理配置，代理只能转发 TCP 连接，不支持基于 UDP 的传输方式
+func CheckProxy(conf *config.ClientConfig) error {
+	if len(conf.Proxy.Addr) == 0 {
+		return nil
+	}
+	switch conf.Proxy.Type {
+	case ProxyHTTP, ProxySOCKS5:
+	default:
+		return fmt.Errorf("unsupported proxy type %s", conf.Proxy.Type)
+	}
+	switch conf.Transport {
+	case "", TCP, GRPC, Websocket:
+	default:
+		return fmt.Errorf("transport %s does not support proxy", conf.Transport)
+	}
+	return nil
+}
+
+// newProxyDial 根据代理配置创建 dialFunc，未配置代理时返回 nil
+func newProxyDial(conf *config.Proxy) (dialFunc, error) {
+	if len(conf.Addr) == 0 {
+		return nil, nil
+	}
+	switch conf.Type {
+	case ProxyHTTP:
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return dialHTTPProxy(ctx, conf, addr)
+		}, nil
+	case ProxySOCKS5:
+		var auth *proxy.Auth
+		if len(conf.Username) > 0 {
+			auth = &proxy.Auth{User: conf.Username, Password: conf.Password}
+		}
+		d, err := proxy.SOCKS5("tcp", conf.Addr, auth, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return func(ctx context.Context, addr string) (net.Conn, error) {
+			return d.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported proxy type %s", conf.Type)
+}
+
+// dialHTTPProxy 通过 HTTP CONNECT 建立到服务端的隧道
+func dialHTTPProxy(ctx context.Context, conf *config.Proxy, addr string) (net.Conn, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", conf.Addr)
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if len(conf.Username) > 0 {
+		auth := base64.StdEncoding.EncodeToString([]byte(conf.Username + ":" + conf.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+auth)
+	}
+	err = req.Write(conn)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy connect %s: %s", addr, resp.Status)
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if br.Buffered() > 0 {
+		// 代理在响应后立即转发了服务端数据
+		return NewBufferedConn(conn, br), nil
+	}
+	return conn, nil
+}
+
+// NewBufferedConn 先读取 reader 中已缓冲的数据，reader 必须是 conn 的读取缓冲
+func NewBufferedConn(conn net.Conn, reader *bufio.Reader) net.Conn {
+	return &bufferedConn{Conn: conn, reader: reader}
+}
+
+// bufferedConn 先读取已缓冲的数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,457 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/quic-go/quic-go"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// quicALPN QUIC 握手使用的应用层协议
+	quicALPN = "gnp"
+	// quicDialTimeout 建立 QUIC 连接和打开流的超时时间
+	quicDialTimeout = time.Second * 10
+	// quicMaxStreams 每个 QUIC 连接允许同时打开的流数量，每个用户会话占用一个流
+	quicMaxStreams = 1 << 16
+	// quicFlowHeaderSize 数据报前缀的流 ID 长度
+	quicFlowHeaderSize = 4
+	// quicFlowQueueSize 客户端每个 UDP 隧道的接收队列长度，队列满时丢弃数据报
+	quicFlowQueueSize = 64
+	// quicMaxIdleTimeout 连接空闲超时，服务端异常退出后客户端在超时后重新建立连接
+	quicMaxIdleTimeout = time.Second * 15
+	// quicMaxPacketSize QUIC 保证的最小数据包 1200 字节扣除包头、AEAD 和数据报帧头后，
+	// 再扣除流 ID 能承载的 UDP 隧道数据包长度，路径 MTU 探测完成前也不会超过数据报大小
+	quicMaxPacketSize = 1150
+)
+
+// quicDatagramDrops 超过数据报大小被丢弃的数据报数量
+var quicDatagramDrops atomic.Uint64
+
+// ClampUDPMTU 返回 UDP 隧道数据包在该传输方式下的最大长度，QUIC 数据报不能超过 quicMaxPacketSize
+func ClampUDPMTU(network string, mtu int) int {
+	if network == QUIC {
+		return min(mtu, quicMaxPacketSize)
+	}
+	return mtu
+}
+
+func quicConfig() *quic.Config {
+	return &quic.Config{
+		MaxIncomingStreams: quicMaxStreams,
+		MaxIdleTimeout:     quicMaxIdleTimeout,
+		KeepAlivePeriod:    quicMaxIdleTimeout / 3,
+		EnableDatagrams:    true,
+	}
+}
+
+// quicAddr 服务端 UDP 隧道地址，由 QUIC 连接和客户端分配的流 ID 组成
+type quicAddr struct {
+	conn quic.Connection
+	flow uint32
+}
+
+func (a quicAddr) Network() string {
+	return QUIC
+}
+
+func (a quicAddr) String() string {
+	return a.conn.RemoteAddr().String() + "/" + strconv.FormatUint(uint64(a.flow), 10)
+}
+
+// sendDatagram 在数据前加上流 ID 作为 QUIC 数据报发送，超过数据报大小的数据和 UDP 一样丢弃
+func sendDatagram(conn quic.Connection, flow uint32, b []byte) (int, error) {
+	buf := make([]byte, quicFlowHeaderSize+len(b))
+	binary.BigEndian.PutUint32(buf, flow)
+	copy(buf[quicFlowHeaderSize:], b)
+	err := conn.SendDatagram(buf)
+	if err != nil {
+		var tooLarge *quic.DatagramTooLargeError
+		if errors.As(err, &tooLarge) {
+			logrus.Warnf("drop datagram size=%d max=%d dropped=%d", len(b), tooLarge.MaxDatagramPayloadSize-quicFlowHeaderSize, quicDatagramDrops.Add(1))
+			return len(b), nil
+		}
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func parseDatagram(b []byte) (uint32, []byte, bool) {
+	if len(b) < quicFlowHeaderSize {
+		return 0, nil, false
+	}
+	return binary.BigEndian.Uint32(b), b[quicFlowHeaderSize:], true
+}
+
+// quicStreamConn 把 QUIC 流封装为 net.Conn
+type quicStreamConn struct {
+	quic.Stream
+	conn quic.Connection
+}
+
+func (c *quicStreamConn) Close() error {
+	c.CancelRead(0)
+	return c.Stream.Close()
+}
+
+func (c *quicStreamConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *quicStreamConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// quicDialer 每个服务端地址复用一个 QUIC 连接，控制连接和 TCP 隧道各使用一个流，UDP 隧道使用数据报
+type quicDialer struct {
+	tlsConf  *tls.Config
+	mx       sync.Mutex
+	sessions map[string]*quicSession
+}
+
+func newQUICDialer(tlsConf *tls.Config) *quicDialer {
+	if tlsConf == nil {
+		// QUIC 必须使用 TLS，未启用 TLS 时不校验服务端证书，安全性与 TCP 传输相同
+		tlsConf = &tls.Config{InsecureSkipVerify: true}
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{quicALPN}
+	return &quicDialer{
+		tlsConf:  tlsConf,
+		sessions: make(map[string]*quicSession),
+	}
+}
+
+func (d *quicDialer) session(addr string) (*quicSession, error) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if s, ok := d.sessions[addr]; ok && s.conn.Context().Err() == nil {
+		return s, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), quicDialTimeout)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, d.tlsConf, quicConfig())
+	if err != nil {
+		return nil, err
+	}
+	s := &quicSession{
+		conn:  conn,
+		flows: make(map[uint32]*datagramConn),
+	}
+	go s.receive()
+	d.sessions[addr] = s
+	return s, nil
+}
+
+func (d *quicDialer) Dial(addr string) (net.Conn, error) {
+	s, err := d.session(addr)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), quicDialTimeout)
+	defer cancel()
+	stream, err := s.conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &quicStreamConn{Stream: stream, conn: s.conn}, nil
+}
+
+func (d *quicDialer) DialPacket(addr string) (net.Conn, error) {
+	s, err := d.session(addr)
+	if err != nil {
+		return nil, err
+	}
+	return s.newFlow(), nil
+}
+
+func (d *quicDialer) Reset(addr string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if s, ok := d.sessions[addr]; ok {
+		_ = s.conn.CloseWithError(0, "")
+		delete(d.sessions, addr)
+	}
+}
+
+func (d *quicDialer) Close() error {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	for addr, s := range d.sessions {
+		_ = s.conn.CloseWithError(0, "")
+		delete(d.sessions, addr)
+	}
+	return nil
+}
+
+// quicSession 客户端 QUIC 连接，按流 ID 分发收到的数据报
+type quicSession struct {
+	conn     quic.Connection
+	mx       sync.Mutex
+	flows    map[uint32]*datagramConn
+	nextFlow atomic.Uint32
+}
+
+func (s *quicSession) newFlow() *datagramConn {
+	c := &datagramConn{
+		session:      s,
+		flow:         s.nextFlow.Add(1),
+		readCh:       make(chan []byte, quicFlowQueueSize),
+		readDeadline: newDeadline(),
+		closeCh:      make(chan struct{}),
+	}
+	s.mx.Lock()
+	s.flows[c.flow] = c
+	s.mx.Unlock()
+	return c
+}
+
+func (s *quicSession) receive() {
+	for {
+		b, err := s.conn.ReceiveDatagram(s.conn.Context())
+		if err != nil {
+			return
+		}
+		flow, data, ok := parseDatagram(b)
+		if !ok {
+			continue
+		}
+		s.mx.Lock()
+		c, ok := s.flows[flow]
+		s.mx.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case c.readCh <- data:
+		default:
+		}
+	}
+}
+
+// datagramConn 客户端 UDP 隧道连接，数据通过 QUIC 数据报收发
+type datagramConn struct {
+	session      *quicSession
+	flow         uint32
+	readCh       chan []byte
+	readDeadline *deadline
+	closeCh      chan struct{}
+	onceClose    sync.Once
+}
+
+func (c *datagramConn) Read(b []byte) (int, error) {
+	select {
+	case data := <-c.readCh:
+		return copy(b, data), nil
+	case <-c.closeCh:
+		return 0, net.ErrClosed
+	case <-c.readDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	case <-c.session.conn.Context().Done():
+		return 0, context.Cause(c.session.conn.Context())
+	}
+}
+
+func (c *datagramConn) Write(b []byte) (int, error) {
+	select {
+	case <-c.closeCh:
+		return 0, net.ErrClosed
+	default:
+	}
+	return sendDatagram(c.session.conn, c.flow, b)
+}
+
+func (c *datagramConn) Close() error {
+	c.onceClose.Do(func() {
+		close(c.closeCh)
+		c.session.mx.Lock()
+		delete(c.session.flows, c.flow)
+		c.session.mx.Unlock()
+	})
+	return nil
+}
+
+func (c *datagramConn) LocalAddr() net.Addr {
+	return c.session.conn.LocalAddr()
+}
+
+func (c *datagramConn) RemoteAddr() net.Addr {
+	return quicAddr{conn: c.session.conn, flow: c.flow}
+}
+
+func (c *datagramConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *datagramConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *datagramConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// listenQUIC 在服务端 UDP 端口上接受 QUIC 连接，流作为控制连接和 TCP 隧道连接，数据报作为 UDP 隧道数据
+func listenQUIC(addr string, tlsConf *tls.Config) (net.Listener, net.PacketConn, error) {
+	if tlsConf == nil {
+		var err error
+		tlsConf, err = selfSignedTLSConfig()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	tlsConf = tlsConf.Clone()
+	tlsConf.NextProtos = []string{quicALPN}
+	udpConn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	tr := &quic.Transport{Conn: udpConn}
+	ln, err := tr.Listen(tlsConf, quicConfig())
+	if err != nil {
+		_ = udpConn.Close()
+		return nil, nil, err
+	}
+	streams := newConnListener(ln.Addr())
+	streams.onClose = func() {
+		_ = ln.Close()
+	}
+	packets := &quicPacketConn{
+		transport:    tr,
+		conn:         udpConn,
+		packetCh:     make(chan packet),
+		readDeadline: newDeadline(),
+		closeCh:      make(chan struct{}),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go packets.receive(conn)
+			go acceptStreams(conn, streams)
+		}
+	}()
+	return streams, packets, nil
+}
+
+func acceptStreams(conn quic.Connection, streams *connListener) {
+	for {
+		stream, err := conn.AcceptStream(conn.Context())
+		if err != nil {
+			return
+		}
+		c := &quicStreamConn{Stream: stream, conn: conn}
+		if !streams.push(conn.Context().Done(), c) {
+			_ = c.Close()
+			return
+		}
+	}
+}
+
+type packet struct {
+	data []byte
+	addr net.Addr
+}
+
+// quicPacketConn 服务端 UDP 隧道连接，合并所有 QUIC 连接的数据报
+type quicPacketConn struct {
+	transport    *quic.Transport
+	conn         net.PacketConn
+	packetCh     chan packet
+	readDeadline *deadline
+	// conns 已建立的 QUIC 连接，关闭时通知客户端
+	conns     sync.Map
+	closeCh   chan struct{}
+	onceClose sync.Once
+}
+
+func (p *quicPacketConn) deliver(data []byte, addr net.Addr) bool {
+	select {
+	case p.packetCh <- packet{data: data, addr: addr}:
+		return true
+	case <-p.closeCh:
+		return false
+	}
+}
+
+func (p *quicPacketConn) receive(conn quic.Connection) {
+	p.conns.Store(conn, struct{}{})
+	defer p.conns.Delete(conn)
+	for {
+		b, err := conn.ReceiveDatagram(conn.Context())
+		if err != nil {
+			return
+		}
+		flow, data, ok := parseDatagram(b)
+		if !ok {
+			continue
+		}
+		if !p.deliver(data, quicAddr{conn: conn, flow: flow}) {
+			return
+		}
+	}
+}
+
+func (p *quicPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	select {
+	case pkt := <-p.packetCh:
+		return copy(b, pkt.data), pkt.addr, nil
+	case <-p.closeCh:
+		return 0, nil, net.ErrClosed
+	case <-p.readDeadline.wait():
+		return 0, nil, os.ErrDeadlineExceeded
+	}
+}
+
+func (p *quicPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	a, ok := addr.(quicAddr)
+	if !ok {
+		return 0, fmt.Errorf("invalid quic addr %s", addr)
+	}
+	return sendDatagram(a.conn, a.flow, b)
+}
+
+func (p *quicPacketConn) Close() error {
+	p.onceClose.Do(func() {
+		close(p.closeCh)
+		// 关闭传输前主动关闭连接，客户端可以立即重连，不需要等待空闲超时
+		p.conns.Range(func(conn, _ any) bool {
+			_ = conn.(quic.Connection).CloseWithError(0, "server closed")
+			return true
+		})
+		_ = p.transport.Close()
+		_ = p.conn.Close()
+	})
+	return nil
+}
+
+func (p *quicPacketConn) LocalAddr() net.Addr {
+	return p.conn.LocalAddr()
+}
+
+func (p *quicPacketConn) SetDeadline(t time.Time) error {
+	p.readDeadline.set(t)
+	return nil
+}
+
+func (p *quicPacketConn) SetReadDeadline(t time.Time) error {
+	p.readDeadline.set(t)
+	return nil
+}
+
+func (p *quicPacketConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
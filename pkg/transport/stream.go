@@ -0,0 +1,219 @@
+package transport
+
+import (
+	"errors"
+	"gnp/pkg/message"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// messageStream 以 ControlMessage 为单位收发的双向流
+type messageStream interface {
+	Send(*message.ControlMessage) error
+	Recv() (*message.ControlMessage, error)
+}
+
+// streamConn 把双向流封装为 net.Conn，字节数据放在 TunnelData 中传输
+type streamConn struct {
+	stream     messageStream
+	localAddr  net.Addr
+	remoteAddr net.Addr
+	// onClose 关闭连接时释放流
+	onClose func()
+	// readCh 接收到的数据
+	readCh chan []byte
+	// readErr 流接收结束的原因，readDone 关闭后有效
+	readErr  error
+	readDone chan struct{}
+	// readBuf 上一次读取未消费完的数据
+	readBuf       []byte
+	readDeadline  *deadline
+	writeDeadline *deadline
+	writeMx       sync.Mutex
+	closeCh       chan struct{}
+	onceClose     sync.Once
+}
+
+func newStreamConn(stream messageStream, localAddr, remoteAddr net.Addr, onClose func()) *streamConn {
+	c := &streamConn{
+		stream:        stream,
+		localAddr:     localAddr,
+		remoteAddr:    remoteAddr,
+		onClose:       onClose,
+		readCh:        make(chan []byte),
+		readDone:      make(chan struct{}),
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		closeCh:       make(chan struct{}),
+	}
+	go c.recv()
+	return c
+}
+
+func (c *streamConn) recv() {
+	defer close(c.readDone)
+	for {
+		msg, err := c.stream.Recv()
+		if err != nil {
+			c.readErr = err
+			return
+		}
+		data := msg.GetTunnelData().GetData()
+		if len(data) == 0 {
+			continue
+		}
+		select {
+		case c.readCh <- data:
+		case <-c.closeCh:
+			c.readErr = net.ErrClosed
+			return
+		}
+	}
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	if len(c.readBuf) == 0 {
+		select {
+		case <-c.closeCh:
+			return 0, net.ErrClosed
+		case <-c.readDeadline.wait():
+			return 0, os.ErrDeadlineExceeded
+		case data := <-c.readCh:
+			c.readBuf = data
+		case <-c.readDone:
+			// 对端正常关闭流时返回 EOF
+			if errors.Is(c.readErr, io.EOF) {
+				return 0, io.EOF
+			}
+			return 0, c.readErr
+		}
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+	select {
+	case <-c.closeCh:
+		return 0, net.ErrClosed
+	case <-c.writeDeadline.wait():
+		return 0, os.ErrDeadlineExceeded
+	default:
+	}
+	err := c.stream.Send(&message.ControlMessage{
+		Ctl:     message.NewTunnelData,
+		Payload: &message.ControlMessage_TunnelData{TunnelData: &message.TunnelData{Data: b}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *streamConn) Close() error {
+	c.onceClose.Do(func() {
+		close(c.closeCh)
+		c.onClose()
+	})
+	return nil
+}
+
+func (c *streamConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *streamConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *streamConn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+	return nil
+}
+
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// streamAddr 没有实际网络地址时使用的地址
+type streamAddr struct {
+	network string
+	addr    string
+}
+
+func (a streamAddr) Network() string {
+	return a.network
+}
+
+func (a streamAddr) String() string {
+	return a.addr
+}
+
+// deadline 可重复设置的超时，超时后 wait 返回的通道关闭
+type deadline struct {
+	mx     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+func (d *deadline) set(t time.Time) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	if d.timer != nil && !d.timer.Stop() {
+		// 定时器已经触发，等待通道关闭
+		<-d.cancel
+	}
+	d.timer = nil
+	closed := isClosed(d.cancel)
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() {
+			close(cancel)
+		})
+		return
+	}
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+func (d *deadline) wait() chan struct{} {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.cancel
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,28 @@
+package transport
+
+import (
+	"context"
+	"gnp/pkg/util"
+	"net"
+)
+
+type tcpDialer struct {
+	udpDialer
+	// dial 配置代理时通过代理连接服务端
+	dial dialFunc
+}
+
+func (d *tcpDialer) Dial(addr string) (net.Conn, error) {
+	if d.dial != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), proxyDialTimeout)
+		defer cancel()
+		return d.dial(ctx, addr)
+	}
+	return util.CreateDialTCP(addr)
+}
+
+func (d *tcpDialer) Reset(string) {}
+
+func (d *tcpDialer) Close() error {
+	return nil
+}
@@ -0,0 +1,57 @@
+package transport
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"gnp/pkg/config"
+	"math/big"
+	"time"
+)
+
+// serverTLSConfig 配置了证书和私钥时启用 TLS
+func serverTLSConfig(conf *config.ServerConfig) (*tls.Config, error) {
+	if len(conf.TLSCertFile) == 0 && len(conf.TLSKeyFile) == 0 {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, nil
+}
+
+func clientTLSConfig(conf *config.ClientConfig) *tls.Config {
+	if !conf.TLS {
+		return nil
+	}
+	return &tls.Config{InsecureSkipVerify: conf.TLSSkipVerify}
+}
+
+// selfSignedTLSConfig 没有配置证书时生成自签名证书，用于必须使用 TLS 的传输方式
+func selfSignedTLSConfig() (*tls.Config, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "gnps"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, nil
+}
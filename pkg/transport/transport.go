@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"fmt"
+	"gnp/pkg/config"
+	"gnp/pkg/util"
+	"net"
+)
+
+// 客户端和服务端之间控制连接和隧道连接的传输方式
+const (
+	TCP       = "tcp"
+	GRPC      = "grpc"
+	Websocket = "websocket"
+	QUIC      = "quic"
+	KCP       = "kcp"
+)
+
+// Dialer 客户端连接服务端
+type Dialer interface {
+	// Dial 建立控制连接和 TCP 隧道连接
+	Dial(addr string) (net.Conn, error)
+	// DialPacket 建立 UDP 隧道连接
+	DialPacket(addr string) (net.Conn, error)
+	// Reset 控制连接心跳超时后丢弃到服务端的复用连接，下次连接时重新建立
+	Reset(addr string)
+	Close() error
+}
+
+// IsSupported 检查传输方式是否支持
+func IsSupported(transport string) bool {
+	switch transport {
+	case "", TCP, GRPC, Websocket, QUIC, KCP:
+		return true
+	}
+	return false
+}
+
+// NewDialer 根据客户端配置创建 Dialer
+func NewDialer(conf *config.ClientConfig) (Dialer, error) {
+	err := CheckProxy(conf)
+	if err != nil {
+		return nil, err
+	}
+	dial, err := newProxyDial(&conf.Proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch conf.Transport {
+	case "", TCP:
+		return &tcpDialer{dial: dial}, nil
+	case GRPC:
+		return newGRPCDialer(clientTLSConfig(conf), dial), nil
+	case Websocket:
+		return newWebsocketDialer(websocketPath(conf.WebsocketPath), clientTLSConfig(conf), dial), nil
+	case QUIC:
+		return newQUICDialer(clientTLSConfig(conf)), nil
+	case KCP:
+		return newKCPDialer(conf.KCP), nil
+	}
+	return nil, fmt.Errorf("unsupported transport %s", conf.Transport)
+}
+
+// Listen 根据服务端配置监听服务端端口，返回控制连接和 TCP 隧道连接的监听以及 UDP 隧道连接
+func Listen(conf *config.ServerConfig) (net.Listener, net.PacketConn, error) {
+	addr := net.JoinHostPort(conf.ServerBind, conf.ServerPort)
+	tlsConf, err := serverTLSConfig(conf)
+	if err != nil {
+		return nil, nil, err
+	}
+	var listener net.Listener
+	switch conf.Transport {
+	case "", TCP:
+		listener, err = net.Listen("tcp", addr)
+	case GRPC:
+		listener, err = listenGRPC(addr, tlsConf)
+	case Websocket:
+		listener, err = listenWebsocket(addr, websocketPath(conf.WebsocketPath), tlsConf)
+	case QUIC:
+		// QUIC 连接和 UDP 隧道连接共用服务端 UDP 端口
+		return listenQUIC(addr, tlsConf)
+	case KCP:
+		// KCP 和 UDP 隧道连接共用服务端 UDP 端口
+		return listenKCP(addr, conf.KCP)
+	default:
+		return nil, nil, fmt.Errorf("unsupported transport %s", conf.Transport)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	packetConn, err := util.CreateListenUDP(conf.ServerBind, conf.ServerPort)
+	if err != nil {
+		_ = listener.Close()
+		return nil, nil, err
+	}
+	return listener, packetConn, nil
+}
+
+// udpDialer 使用原始 UDP 传输 UDP 隧道数据
+type udpDialer struct{}
+
+func (d udpDialer) DialPacket(addr string) (net.Conn, error) {
+	return util.CreateDialUDP(addr)
+}
+
+func websocketPath(path string) string {
+	if len(path) == 0 {
+		return "/"
+	}
+	if path[0] != '/' {
+		return "/" + path
+	}
+	return path
+}
@@ -0,0 +1,114 @@
+package transport
+
+import (
+	"context"
+	"crypto/tls"
+	"github.com/coder/websocket"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// websocketDialTimeout WebSocket 握手超时时间
+const websocketDialTimeout = time.Second * 10
+
+// websocketDialer 每个控制连接和隧道连接对应一个 WebSocket 连接，数据使用二进制消息传输
+type websocketDialer struct {
+	udpDialer
+	scheme string
+	path   string
+	client *http.Client
+}
+
+func newWebsocketDialer(path string, tlsConf *tls.Config, dial dialFunc) *websocketDialer {
+	scheme := "ws"
+	if tlsConf != nil {
+		scheme = "wss"
+	}
+	t := &http.Transport{TLSClientConfig: tlsConf}
+	if dial != nil {
+		t.DialContext = func(ctx context.Context, _, addr string) (net.Conn, error) {
+			return dial(ctx, addr)
+		}
+	}
+	return &websocketDialer{
+		scheme: scheme,
+		path:   path,
+		client: &http.Client{Transport: t},
+	}
+}
+
+func (d *websocketDialer) Dial(addr string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), websocketDialTimeout)
+	defer cancel()
+	u := url.URL{Scheme: d.scheme, Host: addr, Path: d.path}
+	c, _, err := websocket.Dial(ctx, u.String(), &websocket.DialOptions{HTTPClient: d.client})
+	if err != nil {
+		return nil, err
+	}
+	return &websocketConn{
+		Conn:       websocket.NetConn(context.Background(), c, websocket.MessageBinary),
+		remoteAddr: streamAddr{network: Websocket, addr: addr},
+	}, nil
+}
+
+func (d *websocketDialer) Reset(string) {}
+
+func (d *websocketDialer) Close() error {
+	d.client.CloseIdleConnections()
+	return nil
+}
+
+// websocketConn 客户端连接无法获取底层地址，使用服务端地址作为远端地址
+type websocketConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (c *websocketConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// websocketListener 在指定路径上接受 WebSocket 连接
+type websocketListener struct {
+	*connListener
+	server *http.Server
+}
+
+func listenWebsocket(addr, path string, tlsConf *tls.Config) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf != nil {
+		listener = tls.NewListener(listener, tlsConf)
+	}
+	l := &websocketListener{
+		connListener: newConnListener(listener.Addr()),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, l.handle)
+	l.server = &http.Server{Handler: mux}
+	l.onClose = func() {
+		// 已经升级为 WebSocket 的连接不受影响，由服务端自行关闭
+		_ = l.server.Close()
+	}
+	go func() {
+		_ = l.server.Serve(listener)
+	}()
+	return l, nil
+}
+
+func (l *websocketListener) handle(w http.ResponseWriter, r *http.Request) {
+	c, err := websocket.Accept(w, r, nil)
+	if err != nil {
+		logrus.Warnf("accept websocket %v client=%s", err, r.RemoteAddr)
+		return
+	}
+	conn := websocket.NetConn(context.Background(), c, websocket.MessageBinary)
+	if !l.push(r.Context().Done(), conn) {
+		_ = conn.Close()
+	}
+}
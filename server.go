@@ -0,0 +1,143 @@
+package gnp
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"gnp/pkg/auth"
+	"gnp/pkg/config"
+	"gnp/pkg/message"
+	"gnp/pkg/transport"
+	"gnp/server"
+	"sync"
+	"sync/atomic"
+)
+
+// Server 嵌入的服务端实例
+type Server struct {
+	conf    ServerConfig
+	handler EventHandler
+	// authenticator 自定义鉴权方式，为空时按配置鉴权
+	authenticator Authenticator
+	server        *server.Server
+	started       atomic.Bool
+	// done Shutdown 完成后关闭
+	done     chan struct{}
+	onceDone sync.Once
+}
+
+type ServerOption func(*Server)
+
+// WithServerConfig 设置服务端配置，未设置的选项需要从 DefaultServerConfig 开始修改
+func WithServerConfig(conf ServerConfig) ServerOption {
+	return func(s *Server) {
+		s.conf = conf
+	}
+}
+
+// WithServerEventHandler 设置事件回调
+func WithServerEventHandler(handler EventHandler) ServerOption {
+	return func(s *Server) {
+		s.handler = handler
+	}
+}
+
+// WithServerAuthenticator 设置自定义鉴权方式，设置后忽略鉴权配置
+func WithServerAuthenticator(authenticator Authenticator) ServerOption {
+	return func(s *Server) {
+		s.authenticator = authenticator
+	}
+}
+
+// DefaultServerConfig 服务端默认配置，命令行的默认配置也使用这里的值
+func DefaultServerConfig() ServerConfig {
+	return ServerConfig{
+		ServerBind:      "0.0.0.0",
+		ServerPort:      "6000",
+		Transport:       transport.TCP,
+		KCP:             defaultKCP(),
+		AllowPorts:      "1-65535",
+		ConnTimeout:     3600,
+		ShutdownTimeout: 30,
+		ResumeTimeout:   30,
+		// UDP 会话通常很短，比如 DNS 查询，默认空闲超时时间较短
+		UDPConnTimeout: 60,
+		// UDP 隧道分片默认 MTU，留出传输层封装的开销
+		UDPMTU:             1200,
+		UDPFragmentTimeout: 5,
+		UDPQueueSize:       128,
+		UDPQueuePolicy:     message.QueueDropNewest,
+		Auth: config.Auth{
+			Type: auth.TypeToken,
+			// webhook 鉴权需要在客户端等待握手响应超时前返回
+			WebhookTimeout: 2,
+		},
+	}
+}
+
+// NewServer 创建服务端实例，配置错误时返回错误
+func NewServer(opts ...ServerOption) (*Server, error) {
+	s := &Server{
+		conf: DefaultServerConfig(),
+		done: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	err := server.CheckConfig(&s.conf)
+	if err != nil {
+		return nil, err
+	}
+	s.server, err = server.NewServer(s.conf)
+	if err != nil {
+		return nil, err
+	}
+	s.server.SetEventHandler(s.handler)
+	if s.authenticator != nil {
+		s.server.SetAuthenticator(s.authenticator)
+	}
+	return s, nil
+}
+
+// Start 监听服务端端口后返回，ctx 结束时按停机超时时间排空停机
+func (s *Server) Start(ctx context.Context) error {
+	if !s.started.CompareAndSwap(false, true) {
+		return errors.New("server already started")
+	}
+	err := s.server.Start()
+	if err != nil {
+		return err
+	}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = s.Shutdown(context.Background())
+		case <-s.done:
+		}
+	}()
+	return nil
+}
+
+// Shutdown 通知客户端停机并等待用户会话结束，最多等待停机超时时间，ctx 结束时强制关闭所有连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	err := s.server.Shutdown(ctx)
+	s.onceDone.Do(func() {
+		close(s.done)
+	})
+	return err
+}
+
+// UDPDropVars 各代理服务 UDP 队列丢弃的数据包数量，每个实例独立，需要时由调用方发布到 expvar
+func (s *Server) UDPDropVars() *expvar.Map {
+	return s.server.UDPDropVars()
+}
+
+// Reload 重载配置，新配置不再允许的代理服务会被注销，监听地址和传输方式修改后需要重新创建实例
+func (s *Server) Reload(conf ServerConfig) error {
+	err := server.CheckConfig(&conf)
+	if err != nil {
+		return err
+	}
+	s.server.Reload(conf)
+	return nil
+}
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"crypto/subtle"
+	"gnp/pkg/auth"
+	"gnp/pkg/message"
+	"net"
+)
+
+// loginInfo 控制连接登录使用的用户和 token，发送给客户端的消息携带相同的 token
+type loginInfo struct {
+	user  string
+	token string
+	// name 登录插件修改后的用户名，没有插件时和 user 相同
+	name string
+}
+
+// SetAuthenticator 设置自定义鉴权方式，设置后忽略鉴权配置，需要在 Start 之前调用
+func (s *Server) SetAuthenticator(authenticator auth.Authenticator) {
+	s.customAuth = authenticator
+}
+
+// getAuthenticator 获取当前生效的鉴权方式
+func (s *Server) getAuthenticator() auth.Authenticator {
+	if s.customAuth != nil {
+		return s.customAuth
+	}
+	return *s.authenticator.Load()
+}
+
+// login 控制连接的第一个消息调用鉴权方式登录，之后的消息只比较登录的用户和 token
+func (s *Server) login(conn net.Conn, msg *message.ControlMessage) error {
+	err := s.getAuthenticator().Authenticate(s.ctx, &auth.Request{
+		Action:   auth.Login,
+		Addr:     conn.RemoteAddr().String(),
+		User:     msg.GetUser(),
+		Token:    msg.GetToken(),
+		ClientID: msg.GetClientID(),
+	})
+	if err != nil {
+		return err
+	}
+	name, err := s.loginPlugin(conn, msg)
+	if err != nil {
+		return err
+	}
+	s.loginPool.Store(conn, &loginInfo{user: msg.GetUser(), token: msg.GetToken(), name: name})
+	return nil
+}
+
+// auth 校验已登录控制连接的消息，隧道连接比较注册代理服务使用的用户和 token
+func (s *Server) auth(conn net.Conn, msg *message.ControlMessage) bool {
+	if msg.GetCtl() == message.NewTunnel {
+		s.mx.Lock()
+		proxy, ok := s.servicePool[msg.GetServiceID()]
+		s.mx.Unlock()
+		return ok && proxy.ctlMsg.GetUser() == msg.GetUser() && equal(proxy.ctlMsg.GetToken(), msg.GetToken())
+	}
+	info, ok := s.loginPool.Load(conn)
+	return ok && info.(*loginInfo).user == msg.GetUser() && equal(info.(*loginInfo).token, msg.GetToken())
+}
+
+// loggedIn 是否有使用该用户和 token 登录的控制连接，用于校验 UDP 探测
+func (s *Server) loggedIn(msg *message.ControlMessage) bool {
+	var ok bool
+	s.loginPool.Range(func(key, value any) bool {
+		info := value.(*loginInfo)
+		ok = info.user == msg.GetUser() && equal(info.token, msg.GetToken())
+		return !ok
+	})
+	return ok
+}
+
+// authService 校验代理服务注册，配置重载后重新校验已注册的代理服务
+func (s *Server) authService(msg *message.ControlMessage, addr string) error {
+	service := msg.GetRegister().GetService()
+	return s.getAuthenticator().Authenticate(s.ctx, &auth.Request{
+		Action:    auth.Register,
+		Addr:      addr,
+		User:      msg.GetUser(),
+		Token:     msg.GetToken(),
+		ClientID:  msg.GetClientID(),
+		ServiceID: msg.GetServiceID(),
+		Network:   service.GetNetwork(),
+		ProxyPort: service.GetProxyPort(),
+	})
+}
+
+func equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
@@ -0,0 +1,39 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"gnp/pkg/auth"
+	"gnp/pkg/config"
+	"gnp/pkg/message"
+	"gnp/pkg/plugin"
+	"gnp/pkg/transport"
+)
+
+// CheckConfig 校验服务端配置，命令行和嵌入使用共用
+func CheckConfig(conf *config.ServerConfig) error {
+	if !transport.IsSupported(conf.Transport) {
+		return fmt.Errorf("unsupported transport %s", conf.Transport)
+	}
+	if conf.ConnTimeout <= 0 {
+		return errors.New("conn timeout must be greater than 0")
+	}
+	if conf.UDPMTU < message.MinUDPMTU || conf.UDPMTU > message.MaxUDPDataSize {
+		return fmt.Errorf("udp mtu must be between %d and %d", message.MinUDPMTU, message.MaxUDPDataSize)
+	}
+	if conf.UDPConnTimeout <= 0 {
+		return errors.New("udp conn timeout must be greater than 0")
+	}
+	if conf.UDPQueueSize <= 0 {
+		return errors.New("udp queue size must be greater than 0")
+	}
+	if conf.UDPQueuePolicy == "" || !message.IsQueuePolicySupported(conf.UDPQueuePolicy) {
+		return fmt.Errorf("unsupported udp queue policy %s", conf.UDPQueuePolicy)
+	}
+	_, err := auth.New(&conf.Auth, conf.Token)
+	if err != nil {
+		return err
+	}
+	_, err = plugin.NewManager(conf.Plugins)
+	return err
+}
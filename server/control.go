@@ -4,47 +4,167 @@ import (
 	"bufio"
 	"context"
 	"errors"
+	"expvar"
 	"github.com/sanmuyan/xpkg/xnet"
 	"github.com/sirupsen/logrus"
+	"gnp/pkg/auth"
 	"gnp/pkg/config"
+	"gnp/pkg/event"
 	"gnp/pkg/message"
-	"gnp/pkg/util"
+	"gnp/pkg/plugin"
+	"gnp/pkg/transport"
+	"google.golang.org/protobuf/proto"
 	"io"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Server 控制中心
 type Server struct {
-	Config config.ServerConfig
+	// config 当前生效的配置，支持热加载时原子替换
+	config atomic.Pointer[config.ServerConfig]
 	// tunnelConnPool 新建隧道消息池，存储通知代理服务隧道连接信息的队列
 	tunnelConnPool map[string]chan *TunnelConn
 	// tunnelDataPool UDP 隧道数据池，存储代理服务的接收隧道数据的队列
-	tunnelDataPool map[string]chan *TunnelData
+	tunnelDataPool map[string]*udpQueue[*TunnelData]
+	// servicePool 已注册的代理服务
+	servicePool map[string]*ProxyServer
 	// udpTunnelConn UDP 控制连接，接收 UDP 隧道控制消息，接收和发送隧道数据
-	udpTunnelConn *net.UDPConn
-	mx            sync.Mutex
-	wg            *sync.WaitGroup
+	udpTunnelConn net.PacketConn
+	// udpBatch 批量读写 UDP 隧道数据
+	udpBatch transport.BatchConn
+	// listener 控制连接和 TCP 隧道连接的监听
+	listener net.Listener
+	// ctlConnPool 客户端控制连接和握手协商结果，用于停机时通知客户端
+	ctlConnPool sync.Map
+	// loginPool 已登录的控制连接和登录信息
+	loginPool sync.Map
+	// authenticator 按配置创建的鉴权方式，支持热加载时原子替换
+	authenticator atomic.Pointer[auth.Authenticator]
+	// customAuth 嵌入使用时设置的鉴权方式，设置后忽略鉴权配置
+	customAuth auth.Authenticator
+	// plugins 服务端插件，支持热加载时原子替换
+	plugins atomic.Pointer[plugin.Manager]
+	// draining 服务端正在停机，不再接受新的控制连接和用户连接
+	draining atomic.Bool
+	// ctx 服务端运行上下文，停机排空完成或超时后取消，强制关闭所有连接
+	ctx    context.Context
+	cancel context.CancelFunc
+	mx     sync.Mutex
+	wg     *sync.WaitGroup
+	// reloadMx 避免同时重载配置
+	reloadMx sync.Mutex
+	// onceShutdown 避免重复停机
+	onceShutdown sync.Once
+	// handler 事件回调
+	handler event.Handler
+	// udpDropVars UDP 队列丢弃的数据包数量
+	udpDropVars *expvar.Map
 }
 
-func NewServer(config config.ServerConfig) *Server {
-	return &Server{
-		Config:         config,
+func NewServer(conf config.ServerConfig) (*Server, error) {
+	authenticator, err := auth.New(&conf.Auth, conf.Token)
+	if err != nil {
+		return nil, err
+	}
+	plugins, err := plugin.NewManager(conf.Plugins)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
 		tunnelConnPool: make(map[string]chan *TunnelConn),
-		tunnelDataPool: make(map[string]chan *TunnelData),
+		tunnelDataPool: make(map[string]*udpQueue[*TunnelData]),
+		servicePool:    make(map[string]*ProxyServer),
+		wg:             new(sync.WaitGroup),
+		udpDropVars:    new(expvar.Map),
 	}
+	s.ctx, s.cancel = context.WithCancel(context.Background())
+	s.config.Store(&conf)
+	s.authenticator.Store(&authenticator)
+	s.plugins.Store(plugins)
+	return s, nil
 }
 
-func (s *Server) SendMsg(conn net.Conn, msg *message.ControlMessage) error {
-	msg.Token = s.Config.Token
-	return message.WriteTCP(msg, conn)
+// GetConfig 获取当前生效的配置
+func (s *Server) GetConfig() *config.ServerConfig {
+	return s.config.Load()
+}
+
+// SetEventHandler 设置事件回调，需要在 Start 之前调用
+func (s *Server) SetEventHandler(handler event.Handler) {
+	s.handler = handler
+}
+
+// Reload 替换当前配置，并注销新配置不再允许的代理服务，监听地址修改后需要重启生效
+func (s *Server) Reload(conf config.ServerConfig) {
+	s.reloadMx.Lock()
+	defer s.reloadMx.Unlock()
+	oldConf := s.GetConfig()
+	if oldConf.ServerBind != conf.ServerBind || oldConf.ServerPort != conf.ServerPort {
+		logrus.Warnf("server bind address change requires restart")
+	}
+	s.config.Store(&conf)
+	authenticator, err := auth.New(&conf.Auth, conf.Token)
+	if err != nil {
+		logrus.Errorf("reload auth %v, keep previous auth", err)
+	} else {
+		s.authenticator.Store(&authenticator)
+	}
+	plugins, err := plugin.NewManager(conf.Plugins)
+	if err != nil {
+		logrus.Errorf("reload plugins %v, keep previous plugins", err)
+	} else {
+		s.plugins.Store(plugins)
+	}
+	s.mx.Lock()
+	var evicted, proxies []*ProxyServer
+	for serviceID, proxy := range s.servicePool {
+		if !xnet.IsAllowPort(conf.AllowPorts, proxy.GetService().GetProxyPort()) {
+			logrus.Warnf("[%s] port is no longer allowed", serviceID)
+			evicted = append(evicted, proxy)
+			continue
+		}
+		proxies = append(proxies, proxy)
+	}
+	s.mx.Unlock()
+	// 鉴权方式可能请求外部服务，不能持有锁
+	for _, proxy := range proxies {
+		var addr string
+		if ctlConn := proxy.getCtlConn(); ctlConn != nil {
+			addr = ctlConn.RemoteAddr().String()
+		}
+		if err := s.authService(proxy.ctlMsg, addr); err != nil {
+			logrus.Warnf("[%s] service is no longer authorized %v", proxy.ctlMsg.GetServiceID(), err)
+			evicted = append(evicted, proxy)
+		}
+	}
+	for _, proxy := range evicted {
+		if ctlConn := proxy.getCtlConn(); ctlConn != nil {
+			err := s.SendMsg(ctlConn, &message.ControlMessage{
+				Ctl:       message.CloseService,
+				ServiceID: proxy.ctlMsg.GetServiceID(),
+				Payload:   &message.ControlMessage_Rejected{Rejected: &message.Rejected{Reason: "service is no longer permitted"}},
+			})
+			if err != nil {
+				logrus.Errorf("[%s] send ctl message %v", proxy.ctlMsg.GetServiceID(), err)
+			}
+		}
+		go proxy.Stop()
+	}
+	logrus.Infof("config reloaded, evicted %d services", len(evicted))
 }
 
-func (s *Server) auth(msg *message.ControlMessage) bool {
-	if s.Config.Token == msg.GetToken() {
-		return true
+func (s *Server) SendMsg(conn net.Conn, msg *message.ControlMessage) error {
+	msg.Token = s.GetConfig().Token
+	if info, ok := s.loginPool.Load(conn); ok {
+		msg.Token = info.(*loginInfo).token
+	}
+	if message.IsLegacy(s.getHello(conn)) {
+		message.Downgrade(msg)
 	}
-	return false
+	return message.WriteTCP(msg, conn)
 }
 
 func (s *Server) Clean(serviceID string) {
@@ -52,38 +172,98 @@ func (s *Server) Clean(serviceID string) {
 	defer s.mx.Unlock()
 	delete(s.tunnelConnPool, serviceID)
 	delete(s.tunnelDataPool, serviceID)
+	delete(s.servicePool, serviceID)
 }
 
-func (s *Server) handelService(ctx context.Context, msg *message.ControlMessage, conn net.Conn) {
+func (s *Server) handelService(msg *message.ControlMessage, conn net.Conn) {
 	if msg.GetServiceID() == "" {
 		logrus.Warnf("registry service serviceID is empty client=%s", conn.RemoteAddr().String())
+		s.sendRejected(msg, conn, "service id is empty")
 		return
 	}
-	logrus.Infof("[%s] registry service client=%s", msg.GetServiceID(), conn.RemoteAddr().String())
-	if !xnet.IsAllowPort(s.Config.AllowPorts, msg.GetService().GetProxyPort()) {
-		logrus.Warnf("[%s] not allowed port", msg.GetServiceID())
+	service := msg.GetRegister().GetService()
+	logrus.Infof("[%s] registry service client=%s user=%s", msg.GetServiceID(), conn.RemoteAddr().String(), msg.GetUser())
+	err := s.registerPlugin(msg, conn)
+	if err != nil {
+		logrus.Warnf("[%s] registry service rejected by plugin %v", msg.GetServiceID(), err)
+		s.sendRejected(msg, conn, err.Error())
 		return
 	}
-	if _, ok := s.tunnelConnPool[msg.GetServiceID()]; ok {
-		logrus.Warnf("[%s] service is already registered", msg.GetServiceID())
+	// 插件可能修改代理端口，按最终的注册信息鉴权
+	err = s.authService(msg, conn.RemoteAddr().String())
+	if err != nil {
+		logrus.Warnf("[%s] registry service auth failed %v", msg.GetServiceID(), err)
+		s.sendRejected(msg, conn, err.Error())
+		return
+	}
+	if !s.getHello(conn).HasCapability(service.GetNetwork()) {
+		logrus.Warnf("[%s] not supported network %s", msg.GetServiceID(), service.GetNetwork())
+		s.sendRejected(msg, conn, "not supported network")
 		return
 	}
-	switch msg.GetService().GetNetwork() {
+	if service.GetCompression() != "" && (service.GetNetwork() != "tcp" || !message.IsCompressionSupported(service.GetCompression())) {
+		// 不支持的压缩算法按不压缩处理，注册成功消息中返回实际使用的压缩算法
+		logrus.Warnf("[%s] not supported compression %s", msg.GetServiceID(), service.GetCompression())
+		service.Compression = ""
+	}
+	if service.GetNetwork() == "udp" {
+		if !message.IsQueuePolicySupported(service.GetUDPQueuePolicy()) {
+			logrus.Warnf("[%s] not supported udp queue policy %s", msg.GetServiceID(), service.GetUDPQueuePolicy())
+			service.UDPQueuePolicy = ""
+		}
+		if service.GetUDPQueueSize() > maxUDPQueueSize {
+			service.UDPQueueSize = maxUDPQueueSize
+		}
+	}
+	if !xnet.IsAllowPort(s.GetConfig().AllowPorts, service.GetProxyPort()) {
+		logrus.Warnf("[%s] not allowed port", msg.GetServiceID())
+		s.sendRejected(msg, conn, "not allowed port")
+		return
+	}
+	s.mx.Lock()
+	proxy, ok := s.servicePool[msg.GetServiceID()]
+	s.mx.Unlock()
+	if ok {
+		// 只有注册该服务的客户端可以恢复或者更新代理服务
+		if msg.GetClientID() == "" || proxy.ctlMsg.GetClientID() != msg.GetClientID() {
+			logrus.Warnf("[%s] service is already registered", msg.GetServiceID())
+			s.sendRejected(msg, conn, "service is already registered")
+			return
+		}
+		if proto.Equal(proxy.GetService(), service) {
+			s.resumeService(proxy, conn)
+			s.sendReady(msg, conn)
+			return
+		}
+		logrus.Infof("[%s] service changed, close old service", msg.GetServiceID())
+		proxy.Stop()
+	}
+	switch service.GetNetwork() {
 	case "tcp":
-		proxy := NewTCPProxy(NewProxyServer(ctx, s, conn, msg))
+		proxy := NewTCPProxy(NewProxyServer(s.ctx, s, conn, msg))
+		s.mx.Lock()
 		s.tunnelConnPool[msg.GetServiceID()] = proxy.tunnelConnCh
+		s.servicePool[msg.GetServiceID()] = proxy.ProxyServer
+		s.mx.Unlock()
 		go proxy.Start()
 	case "udp":
-		proxy := NewUDPProxy(NewProxyServer(ctx, s, conn, msg), s.udpTunnelConn)
+		proxy := NewUDPProxy(NewProxyServer(s.ctx, s, conn, msg), s.udpTunnelConn)
+		s.mx.Lock()
 		s.tunnelConnPool[msg.GetServiceID()] = proxy.tunnelConnCh
 		s.tunnelDataPool[msg.GetServiceID()] = proxy.tunnelData
+		s.servicePool[msg.GetServiceID()] = proxy.ProxyServer
+		s.mx.Unlock()
 		go proxy.Start()
 	}
+	s.handler.Emit(event.Event{Type: event.ServiceRegistered, ServiceID: msg.GetServiceID(), Addr: conn.RemoteAddr().String()})
+	s.sendReady(msg, conn)
+}
+
+func (s *Server) sendReady(msg *message.ControlMessage, conn net.Conn) {
 	readyMsg := &message.ControlMessage{
 		Ctl:       message.ServiceReady,
-		Service:   msg.GetService(),
 		ServiceID: msg.GetServiceID(),
-		SessionID: msg.GetSessionID(),
+		Payload:   &message.ControlMessage_Ready{Ready: &message.Ready{Service: msg.GetRegister().GetService()}},
 	}
 	err := s.SendMsg(conn, readyMsg)
 	if err != nil {
@@ -92,6 +272,69 @@ func (s *Server) handelService(ctx context.Context, msg *message.ControlMessage,
 	}
 }
 
+// sendRejected 通知客户端代理服务注册被拒绝
+func (s *Server) sendRejected(msg *message.ControlMessage, conn net.Conn, reason string) {
+	err := s.SendMsg(conn, &message.ControlMessage{
+		Ctl:       message.ServiceRejected,
+		ServiceID: msg.GetServiceID(),
+		Payload:   &message.ControlMessage_Rejected{Rejected: &message.Rejected{Reason: reason}},
+	})
+	if err != nil {
+		logrus.Errorf("[%s] send ctl message %v", msg.ServiceID, err)
+	}
+	s.handler.Emit(event.Event{Type: event.ServiceRejected, ServiceID: msg.GetServiceID(), Addr: conn.RemoteAddr().String(), Reason: reason})
+}
+
+// handelHandshake 处理客户端握手，协商协议版本和能力，协商失败时返回 false 关闭连接
+func (s *Server) handelHandshake(msg *message.ControlMessage, conn net.Conn) bool {
+	hello, err := message.Negotiate(message.NewHello(), msg.GetHello())
+	if err != nil {
+		logrus.Errorf("handshake failed %v client=%s version=%s", err, conn.RemoteAddr().String(), msg.GetHello().GetVersion())
+		_ = s.SendMsg(conn, &message.ControlMessage{
+			Ctl:     message.Handshake,
+			Payload: &message.ControlMessage_Hello{Hello: &message.Hello{ProtocolVersion: message.ProtocolVersion, Version: message.Version, Error: err.Error()}},
+		})
+		return false
+	}
+	logrus.Infof("handshake client=%s version=%s protocol=%d capabilities=%v", conn.RemoteAddr().String(), msg.GetHello().GetVersion(), hello.GetProtocolVersion(), hello.GetCapabilities())
+	s.ctlConnPool.Store(conn, hello)
+	err = s.SendMsg(conn, &message.ControlMessage{
+		Ctl:     message.Handshake,
+		Payload: &message.ControlMessage_Hello{Hello: hello},
+	})
+	if err != nil {
+		logrus.Errorf("send handshake message %v", err)
+		return false
+	}
+	s.handler.Emit(event.Event{Type: event.Connected, Addr: conn.RemoteAddr().String()})
+	return true
+}
+
+// getHello 获取控制连接的握手协商结果
+func (s *Server) getHello(conn net.Conn) *message.Hello {
+	if hello, ok := s.ctlConnPool.Load(conn); ok {
+		return hello.(*message.Hello)
+	}
+	return message.LegacyHello
+}
+
+// handelCloseService 处理客户端注销代理服务，只允许注册该服务的控制连接注销
+func (s *Server) handelCloseService(msg *message.ControlMessage, conn net.Conn) {
+	s.mx.Lock()
+	proxy, ok := s.servicePool[msg.GetServiceID()]
+	s.mx.Unlock()
+	if !ok {
+		logrus.Warnf("[%s] service is not registered", msg.GetServiceID())
+		return
+	}
+	if proxy.getCtlConn() != conn {
+		logrus.Warnf("[%s] service is not registered by client=%s", msg.GetServiceID(), conn.RemoteAddr().String())
+		return
+	}
+	logrus.Infof("[%s] unregister service client=%s", msg.GetServiceID(), conn.RemoteAddr().String())
+	proxy.Stop()
+}
+
 // controller 处理服务端控制消息
 func (s *Server) controller(ctx context.Context, conn net.Conn) {
 	var isNewTunnelConn bool
@@ -102,6 +345,14 @@ func (s *Server) controller(ctx context.Context, conn net.Conn) {
 		if !isNewTunnelConn {
 			_ = conn.Close()
 		}
+		s.loginPool.Delete(conn)
+		if _, ok := s.ctlConnPool.LoadAndDelete(conn); ok {
+			s.handler.Emit(event.Event{Type: event.Disconnected, Addr: conn.RemoteAddr().String()})
+		}
+		// 停机排空期间保留代理服务，等待已有用户会话结束
+		if !s.draining.Load() {
+			s.detachServices(conn)
+		}
 		cancel()
 		s.wg.Done()
 	}()
@@ -120,28 +371,75 @@ func (s *Server) controller(ctx context.Context, conn net.Conn) {
 				logrus.Errorf("read ctl message %v", err)
 				return
 			}
-			if !s.auth(msg) {
+			if _, ok := s.loginPool.Load(conn); !ok && msg.GetCtl() != message.NewTunnel {
+				// 登录失败关闭连接，避免重复调用鉴权方式
+				err := s.login(conn, msg)
+				if err != nil {
+					logrus.Warnf("login failed %v client=%s user=%s", err, conn.RemoteAddr().String(), msg.GetUser())
+					return
+				}
+			} else if !s.auth(conn, msg) {
 				logrus.Warnf("auth failed client=%s", conn.RemoteAddr().String())
 				continue
 			}
+			if msg.GetCtl() != message.NewTunnel {
+				if _, ok := s.ctlConnPool.Load(conn); !ok {
+					// 停机期间只允许已有用户会话的隧道连接
+					if s.draining.Load() {
+						logrus.Infof("server is draining, reject client=%s", conn.RemoteAddr().String())
+						_ = s.SendMsg(conn, &message.ControlMessage{Ctl: message.ServerShutdown})
+						return
+					}
+					if msg.GetCtl() != message.Handshake {
+						logrus.Warnf("client without handshake, use legacy protocol client=%s", conn.RemoteAddr().String())
+						s.ctlConnPool.Store(conn, message.LegacyHello)
+						s.handler.Emit(event.Event{Type: event.Connected, Addr: conn.RemoteAddr().String()})
+					}
+				}
+			}
 			switch msg.GetCtl() {
 			case message.NewTunnel:
 				// 隧道连接加入对应代理队列
-				if _, ok := s.tunnelConnPool[msg.GetServiceID()]; !ok {
+				s.mx.Lock()
+				proxy, ok := s.servicePool[msg.GetServiceID()]
+				tunnelConnCh := s.tunnelConnPool[msg.GetServiceID()]
+				s.mx.Unlock()
+				if !ok || tunnelConnCh == nil {
 					logrus.Warnf("[%s] service is not registered", msg.GetServiceID())
 					return
 				}
-				s.tunnelConnPool[msg.GetServiceID()] <- NewTunnelConn(conn, msg, nil)
+				tunnelConn := NewTunnelConn(conn, msg, nil)
+				tunnelConn.reader = reader
+				select {
+				case tunnelConnCh <- tunnelConn:
+				case <-proxy.ctx.Done():
+					// 代理服务已经停止，关闭隧道连接
+					logrus.Warnf("[%s] service is stopped", msg.GetServiceID())
+					return
+				}
 				isNewTunnelConn = true
 				// 隧道连接需要直接 return 退出循环，否则代理转发逻辑无法读取隧道连接
 				return
+			case message.Handshake:
+				if !s.handelHandshake(msg, conn) {
+					return
+				}
+				continue
 			case message.NewService:
 				// 处理客户端服务代理注册
-				s.handelService(ctx, msg, conn)
+				s.handelService(msg, conn)
+				continue
+			case message.CloseService:
+				// 处理客户端服务代理注销
+				s.handelCloseService(msg, conn)
 				continue
 			case message.KeepAlive:
 				err := s.SendMsg(conn, &message.ControlMessage{
 					Ctl: message.KeepAlive,
+					Payload: &message.ControlMessage_Heartbeat{Heartbeat: &message.Heartbeat{
+						SendTime:  msg.GetHeartbeat().GetSendTime(),
+						ReplyTime: time.Now().UnixNano(),
+					}},
 				})
 				if err != nil {
 					logrus.Errorf("send keep alive message %v", err)
@@ -156,19 +454,71 @@ func (s *Server) controller(ctx context.Context, conn net.Conn) {
 }
 
 // udpController 处理 UDP 控制消息和数据
-func (s *Server) udpController(data []byte, remoteAddr *net.UDPAddr) {
-	msg, err := message.Unmarshal(data)
+func (s *Server) udpController(data []byte, remoteAddr net.Addr) {
+	msg, err := message.UnmarshalUDP(data)
 	if err != nil {
 		logrus.Warnf("unmarshal udp tunnel %s", err)
 		return
 	}
+	sessionID := msg.GetTunnelData().GetSessionID()
 	switch msg.GetCtl() {
 	case message.NewTunnel:
-		s.tunnelConnPool[msg.GetServiceID()] <- NewTunnelConn(nil, msg, remoteAddr)
+		sessionID = msg.GetTunnel().GetSessionID()
 	case message.NewTunnelData:
-		s.tunnelDataPool[msg.GetServiceID()] <- NewTunnelData(msg, remoteAddr)
+	case message.KeepAlive:
+		// 客户端探测 UDP 是否可用
+		s.replyUDPProbe(msg, remoteAddr)
+		return
 	default:
 		logrus.Warnf("[%s] unknown ctl:=%d", msg.GetServiceID(), msg.GetCtl())
+		return
+	}
+	s.mx.Lock()
+	proxy, ok := s.servicePool[msg.GetServiceID()]
+	tunnelData := s.tunnelDataPool[msg.GetServiceID()]
+	s.mx.Unlock()
+	if !ok || tunnelData == nil {
+		logrus.Debugf("[%s] udp tunnel service not found remote=%s", msg.GetServiceID(), remoteAddr.String())
+		return
+	}
+	// 只接收已有用户会话的数据包，校验签名、序号和源地址，避免伪造的数据包注入用户会话
+	userConn, ok := proxy.userConnPool.Load(sessionID)
+	if !ok {
+		logrus.Debugf("[%s] udp tunnel session not found sessionID:=%s remote=%s", msg.GetServiceID(), sessionID, remoteAddr.String())
+		return
+	}
+	err = userConn.(*UDPUserConn).verify(msg, remoteAddr)
+	if err != nil {
+		logrus.Debugf("[%s] drop udp tunnel packet sessionID:=%s remote=%s %v", msg.GetServiceID(), sessionID, remoteAddr.String(), err)
+		return
+	}
+	switch msg.GetCtl() {
+	case message.NewTunnel:
+		select {
+		case proxy.tunnelConnCh <- NewTunnelConn(nil, msg, remoteAddr):
+		case <-proxy.ctx.Done():
+		}
+	case message.NewTunnelData:
+		tunnelData.push(proxy.ctx, NewTunnelData(msg, remoteAddr))
+	}
+}
+
+// replyUDPProbe 响应客户端的 UDP 探测，只响应鉴权通过的探测，避免被用于反射放大
+func (s *Server) replyUDPProbe(msg *message.ControlMessage, remoteAddr net.Addr) {
+	if !s.loggedIn(msg) {
+		logrus.Debugf("udp probe auth failed remote=%s", remoteAddr.String())
+		return
+	}
+	err := message.WriteToUDP(&message.ControlMessage{
+		Ctl:   message.KeepAlive,
+		Token: msg.GetToken(),
+		Payload: &message.ControlMessage_Heartbeat{Heartbeat: &message.Heartbeat{
+			SendTime:  msg.GetHeartbeat().GetSendTime(),
+			ReplyTime: time.Now().UnixNano(),
+		}},
+	}, s.udpTunnelConn, remoteAddr, false)
+	if err != nil {
+		logrus.Debugf("reply udp probe %v", err)
 	}
 }
 
@@ -187,39 +537,42 @@ func (s *Server) handleConn(ctx context.Context, listener net.Listener) {
 }
 
 func (s *Server) handleUDPConn() {
-	for {
-		buf := make([]byte, message.BufDataSize)
-		n, remoteAddr, err := s.udpTunnelConn.ReadFromUDP(buf)
-		if err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				return
-			}
-			logrus.Errorf("read udp tunnel %s", err)
-			return
-		}
-		go s.udpController(buf[:n], remoteAddr)
+	workers := newWorkerPool(func(p udpPacket) {
+		s.udpController(p.data(), p.addr)
+	})
+	defer workers.close()
+	// 紧凑格式的隧道数据按会话分配处理协程，其他消息按来源地址分配
+	err := readPackets(s.udpBatch, workers, message.CompactSessionID)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		logrus.Errorf("read udp tunnel %s", err)
 	}
 }
 
-func Run(ctx context.Context) {
-	listener, err := util.CreateListenTCP(config.ServerConf.ServerBind, config.ServerConf.ServerPort)
+// Start 监听服务端端口，开始接受客户端连接，监听失败时返回错误
+func (s *Server) Start() error {
+	conf := s.GetConfig()
+	listener, udpTunnelConn, err := transport.Listen(conf)
 	if err != nil {
-		logrus.Fatalf("server listen %v", err)
-	}
-	logrus.Infof("server listening on %s", net.JoinHostPort(config.ServerConf.ServerBind, config.ServerConf.ServerPort))
-	s := NewServer(config.ServerConf)
-	udpTunnelConn, err := util.CreateListenUDP(config.ServerConf.ServerBind, config.ServerConf.ServerPort)
-	if err != nil {
-		logrus.Fatalf("server listen %v", err)
+		return err
 	}
+	logrus.Infof("server listening on %s transport=%s", net.JoinHostPort(conf.ServerBind, conf.ServerPort), conf.Transport)
+	s.listener = listener
 	s.udpTunnelConn = udpTunnelConn
-	defer func() {
-		_ = listener.Close()
-		_ = s.udpTunnelConn.Close()
-	}()
-	s.wg = new(sync.WaitGroup)
+	s.udpBatch = transport.NewBatchConn(udpTunnelConn)
 	go s.handleUDPConn()
-	go s.handleConn(ctx, listener)
-	<-ctx.Done()
-	s.wg.Wait()
+	go s.handleConn(s.ctx, listener)
+	return nil
+}
+
+// Shutdown 排空停机后关闭监听，ctx 结束时不再等待用户会话，强制关闭所有连接
+func (s *Server) Shutdown(ctx context.Context) error {
+	var err error
+	s.onceShutdown.Do(func() {
+		err = s.shutdown(ctx)
+		if s.listener != nil {
+			_ = s.listener.Close()
+			_ = s.udpTunnelConn.Close()
+		}
+	})
+	return err
 }
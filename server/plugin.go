@@ -0,0 +1,89 @@
+package server
+
+import (
+	"github.com/sirupsen/logrus"
+	"gnp/pkg/message"
+	"gnp/pkg/plugin"
+	"net"
+)
+
+// getPlugins 获取当前生效的服务端插件
+func (s *Server) getPlugins() *plugin.Manager {
+	return s.plugins.Load()
+}
+
+// loginPlugin 调用登录插件，返回插件修改后的用户名
+func (s *Server) loginPlugin(conn net.Conn, msg *message.ControlMessage) (string, error) {
+	content := &plugin.LoginContent{
+		Addr:     conn.RemoteAddr().String(),
+		User:     msg.GetUser(),
+		ClientID: msg.GetClientID(),
+		Version:  msg.GetHello().GetVersion(),
+	}
+	err := s.getPlugins().Handle(s.ctx, plugin.Login, content)
+	return content.User, err
+}
+
+// loginName 控制连接登录插件修改后的用户名，用于之后的插件请求
+func (s *Server) loginName(conn net.Conn) string {
+	if info, ok := s.loginPool.Load(conn); ok {
+		return info.(*loginInfo).name
+	}
+	return ""
+}
+
+// registerPlugin 调用注册插件，插件只能修改代理端口、空闲超时时间和 UDP 队列配置，修改后仍然按服务端配置校验
+func (s *Server) registerPlugin(msg *message.ControlMessage, conn net.Conn) error {
+	if !s.getPlugins().Has(plugin.Register) {
+		return nil
+	}
+	service := msg.GetRegister().GetService()
+	content := &plugin.RegisterContent{
+		Addr:           conn.RemoteAddr().String(),
+		User:           s.loginName(conn),
+		ClientID:       msg.GetClientID(),
+		ServiceID:      msg.GetServiceID(),
+		Network:        service.GetNetwork(),
+		ProxyPort:      service.GetProxyPort(),
+		ConnTimeout:    service.GetConnTimeout(),
+		UDPQueueSize:   service.GetUDPQueueSize(),
+		UDPQueuePolicy: service.GetUDPQueuePolicy(),
+	}
+	err := s.getPlugins().Handle(s.ctx, plugin.Register, content)
+	if err != nil {
+		return err
+	}
+	if content.ProxyPort != service.GetProxyPort() {
+		logrus.Infof("[%s] plugin change proxy port %s to %s", msg.GetServiceID(), service.GetProxyPort(), content.ProxyPort)
+	}
+	service.ProxyPort = content.ProxyPort
+	service.ConnTimeout = content.ConnTimeout
+	service.UDPQueueSize = content.UDPQueueSize
+	service.UDPQueuePolicy = content.UDPQueuePolicy
+	return nil
+}
+
+// sessionPlugin 调用新建会话插件，插件可以修改会话的空闲超时时间
+func (p *ProxyServer) sessionPlugin(userConn UserConnProvider) error {
+	if !p.getPlugins().Has(plugin.NewSession) {
+		return nil
+	}
+	content := &plugin.NewSessionContent{
+		User:        p.user,
+		ClientID:    p.ctlMsg.GetClientID(),
+		ServiceID:   p.ctlMsg.GetServiceID(),
+		Network:     p.GetService().GetNetwork(),
+		ProxyPort:   p.GetService().GetProxyPort(),
+		SessionID:   userConn.GetSessionID(),
+		RemoteAddr:  userConn.GetRemoteAddr(),
+		ConnTimeout: p.connTimeout(),
+	}
+	err := p.getPlugins().Handle(p.ctx, plugin.NewSession, content)
+	if err != nil {
+		return err
+	}
+	if content.ConnTimeout != p.connTimeout() {
+		userConn.SetConnTimeout(content.ConnTimeout)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"context"
 	"github.com/sirupsen/logrus"
+	"gnp/pkg/event"
 	"gnp/pkg/message"
 	"net"
 	"reflect"
@@ -18,36 +19,76 @@ type ProxyProvider interface {
 // ProxyServer 代理服务，处理用户访问代理
 type ProxyServer struct {
 	*Server
-	ctx context.Context
+	ctx    context.Context
+	cancel context.CancelFunc
+	// done 代理服务关闭完成通知
+	done chan struct{}
 	// ctlMsg 代理服务注册信息
 	ctlMsg *message.ControlMessage
-	// ctlConn 服务器控制端连接，用于发送新建隧道请求
+	// ctlConn 服务器控制端连接，用于发送新建隧道请求，控制连接断开等待恢复时为空
 	ctlConn net.Conn
+	// detachTimer 控制连接断开后的恢复等待计时器
+	detachTimer *time.Timer
 	// userConnPool 存储用户连接池
 	userConnPool sync.Map
 	// 新建隧道连接通知队列
 	tunnelConnCh chan *TunnelConn
+	// legacy 客户端只支持旧版本协议的共享字段
+	legacy bool
+	// compressStats 代理服务的隧道数据压缩统计
+	compressStats *message.CompressStats
+	// udpAuth 客户端支持 UDP 隧道数据包签名
+	udpAuth bool
+	// udpFragment 客户端支持 UDP 隧道数据分片
+	udpFragment bool
+	// udpCompact 客户端支持紧凑格式的 UDP 隧道数据
+	udpCompact bool
+	// udpDrops UDP 队列丢弃的数据包统计
+	udpDrops *udpDropStats
+	// user 注册代理服务的用户名，用于插件请求
+	user string
 }
 
 func NewProxyServer(ctx context.Context, server *Server, ctlConn net.Conn, ctlMsg *message.ControlMessage) *ProxyServer {
+	ctx, cancel := context.WithCancel(ctx)
+	server.wg.Add(1)
 	return &ProxyServer{
-		ctx:          ctx,
-		Server:       server,
-		ctlMsg:       ctlMsg,
-		ctlConn:      ctlConn,
-		tunnelConnCh: make(chan *TunnelConn),
+		ctx:           ctx,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+		Server:        server,
+		ctlMsg:        ctlMsg,
+		ctlConn:       ctlConn,
+		tunnelConnCh:  make(chan *TunnelConn),
+		legacy:        message.IsLegacy(server.getHello(ctlConn)),
+		compressStats: new(message.CompressStats),
+		udpAuth:       server.getHello(ctlConn).HasCapability(message.CapUDPAuth),
+		udpFragment:   server.getHello(ctlConn).HasCapability(message.CapFragment),
+		udpCompact:    server.getHello(ctlConn).HasCapability(message.CapCompactData),
+		udpDrops:      &udpDropStats{serviceID: ctlMsg.GetServiceID(), vars: server.udpDropVars},
+		user:          server.loginName(ctlConn),
 	}
 }
 
-func (p *ProxyServer) NewTunnel(sessionID string) {
+func (p *ProxyServer) NewTunnel(userConn UserConnProvider) {
+	sessionID := userConn.GetSessionID()
 	logrus.Infof("[%s] new request sessionID:=%s", p.ctlMsg.GetServiceID(), sessionID)
 	msg := &message.ControlMessage{
 		Ctl:       message.NewTunnel,
-		Service:   p.ctlMsg.GetService(),
 		ServiceID: p.ctlMsg.GetServiceID(),
-		SessionID: sessionID,
+		Payload: &message.ControlMessage_Tunnel{Tunnel: &message.Tunnel{
+			Service:   p.GetService(),
+			SessionID: sessionID,
+			Key:       userConn.GetSessionKey(),
+		}},
 	}
-	err := p.Server.SendMsg(p.ctlConn, msg)
+	ctlConn := p.getCtlConn()
+	if ctlConn == nil {
+		// 控制连接恢复后会重新通知客户端新建隧道
+		logrus.Warnf("[%s] client is disconnected, wait for resume sessionID:=%s", p.ctlMsg.GetServiceID(), sessionID)
+		return
+	}
+	err := p.Server.SendMsg(ctlConn, msg)
 	if err != nil {
 		logrus.Errorf("[%s] send ctl message %v", p.ctlMsg.GetServiceID(), err)
 	}
@@ -55,8 +96,11 @@ func (p *ProxyServer) NewTunnel(sessionID string) {
 
 func (p *ProxyServer) CleanUserConn() {
 	// 清理超时的用户连接
-	t := time.NewTicker(time.Second * time.Duration(p.Config.ConnTimeout))
+	t := time.NewTicker(time.Second * time.Duration(p.connTimeout()))
+	defer t.Stop()
 	for range t.C {
+		// 热加载后按新的超时时间检查
+		t.Reset(time.Second * time.Duration(p.connTimeout()))
 		select {
 		case <-p.ctx.Done():
 			return
@@ -68,7 +112,7 @@ func (p *ProxyServer) CleanUserConn() {
 					return true
 				}
 				if !userConn.IsTunnelAvailable() {
-					if userConn.GetCreateTime()+int64(p.Config.ConnTimeout) > time.Now().Unix() {
+					if userConn.GetCreateTime()+int64(p.connTimeout()) > time.Now().Unix() {
 						p.RemoveUserConn(userConn.GetSessionID())
 						logrus.Debugf("[%s] delete no tunnel userConn sessionID:=%s", p.ctlMsg.GetServiceID(), userConn.GetSessionID())
 					}
@@ -91,15 +135,104 @@ func (p *ProxyServer) WatchTunnel() {
 				continue
 			}
 			userConn := userConnProvider.(UserConnProvider)
-			userConn.SetTunnelConn(tunnelConn)
-			// 读取用户数据转发到隧道
-			go userConn.TunnelToUser()
-			// 读取隧道数据转发到用户
-			go userConn.UserToTunnel()
+			// 重复的新建隧道请求不能再次配对
+			if !userConn.StartPairing(tunnelConn) {
+				logrus.Debugf("[%s] tunnel already exists sessionID:=%s", p.ctlMsg.GetServiceID(), tunnelConn.GetSessionID())
+				tunnelConn.Close()
+				continue
+			}
+			// 插件请求可能较慢，不能阻塞其他会话配对
+			go p.pairTunnel(userConn, tunnelConn)
 		}
 	}
 }
 
+// pairTunnel 新建会话插件通过后配对用户连接和隧道连接，开始转发数据
+func (p *ProxyServer) pairTunnel(userConn UserConnProvider, tunnelConn *TunnelConn) {
+	err := p.sessionPlugin(userConn)
+	if err != nil {
+		logrus.Warnf("[%s] session rejected by plugin %v sessionID:=%s", p.ctlMsg.GetServiceID(), err, userConn.GetSessionID())
+		// 关闭用户连接时同时关闭正在配对的隧道连接
+		userConn.Close()
+		return
+	}
+	err = userConn.SetTunnelConn(tunnelConn)
+	if err != nil {
+		logrus.Errorf("[%s] set tunnel %v sessionID:=%s", p.ctlMsg.GetServiceID(), err, userConn.GetSessionID())
+		// 关闭用户连接时同时关闭正在配对的隧道连接
+		userConn.Close()
+		return
+	}
+	// 读取用户数据转发到隧道
+	go userConn.TunnelToUser()
+	// 读取隧道数据转发到用户
+	go userConn.UserToTunnel()
+}
+
 func (p *ProxyServer) RemoveUserConn(sessionID string) {
 	p.userConnPool.Delete(sessionID)
 }
+
+// udpQueueSize UDP 服务的队列长度，客户端没有设置时使用服务端配置
+func (p *ProxyServer) udpQueueSize() int {
+	if size := p.GetService().GetUDPQueueSize(); size > 0 {
+		return int(size)
+	}
+	return p.GetConfig().UDPQueueSize
+}
+
+// connTimeout 会话空闲超时时间，客户端没有设置时按服务类型使用服务端配置
+func (p *ProxyServer) connTimeout() int {
+	if timeout := p.GetService().GetConnTimeout(); timeout > 0 {
+		return int(timeout)
+	}
+	if p.GetService().GetNetwork() == "udp" {
+		return p.GetConfig().UDPConnTimeout
+	}
+	return p.GetConfig().ConnTimeout
+}
+
+// udpQueuePolicy UDP 服务队列满时的处理策略，客户端没有设置时使用服务端配置
+func (p *ProxyServer) udpQueuePolicy() string {
+	if policy := p.GetService().GetUDPQueuePolicy(); policy != "" {
+		return policy
+	}
+	return p.GetConfig().UDPQueuePolicy
+}
+
+// GetService 获取代理服务注册信息
+func (p *ProxyServer) GetService() *message.Service {
+	return p.ctlMsg.GetRegister().GetService()
+}
+
+// getCtlConn 获取当前控制连接
+func (p *ProxyServer) getCtlConn() net.Conn {
+	p.Server.mx.Lock()
+	defer p.Server.mx.Unlock()
+	return p.ctlConn
+}
+
+// Stop 注销代理服务，等待代理服务关闭完成
+func (p *ProxyServer) Stop() {
+	p.cancel()
+	<-p.done
+}
+
+// finish 代理服务退出时关闭所有用户连接并清理注册信息
+func (p *ProxyServer) finish() {
+	p.userConnPool.Range(func(key, value any) bool {
+		value.(UserConnProvider).Close()
+		return true
+	})
+	p.Server.Clean(p.ctlMsg.GetServiceID())
+	close(p.done)
+	p.Server.wg.Done()
+	logrus.Infof("[%s] close service", p.ctlMsg.GetServiceID())
+	p.handler.Emit(event.Event{Type: event.ServiceClosed, ServiceID: p.ctlMsg.GetServiceID()})
+	if compression := p.GetService().GetCompression(); compression != "" {
+		logrus.Infof("[%s] compression %s %s", p.ctlMsg.GetServiceID(), compression, p.compressStats)
+	}
+	if p.udpDrops.total() > 0 {
+		logrus.Infof("[%s] udp queue dropped %s", p.ctlMsg.GetServiceID(), p.udpDrops)
+	}
+}
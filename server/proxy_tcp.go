@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"github.com/sirupsen/logrus"
+	"gnp/pkg/event"
 	"gnp/pkg/util"
 	"net"
 )
@@ -20,7 +21,8 @@ func NewTCPProxy(proxyServer *ProxyServer) *TCPProxy {
 }
 
 func (p *TCPProxy) Start() {
-	listener, err := util.CreateListenTCP(p.Config.ServerBind, p.ctlMsg.Service.ProxyPort)
+	defer p.finish()
+	listener, err := util.CreateListenTCP(p.GetConfig().ServerBind, p.GetService().GetProxyPort())
 	if errors.Is(err, net.ErrClosed) {
 		return
 	}
@@ -37,9 +39,7 @@ func (p *TCPProxy) Start() {
 }
 
 func (p *TCPProxy) Close() {
-	p.Server.Clean(p.ctlMsg.GetServiceID())
 	_ = p.listener.Close()
-	logrus.Infof("[%s] close service", p.ctlMsg.ServiceID)
 }
 
 func (p *TCPProxy) handelConn() {
@@ -52,6 +52,11 @@ func (p *TCPProxy) handelConn() {
 			logrus.Errorf("[%s] accept proxy connect %s", p.ctlMsg.ServiceID, err)
 			return
 		}
+		if p.draining.Load() {
+			// 停机排空期间不再接受新的用户连接
+			_ = conn.Close()
+			continue
+		}
 		go p.controller(conn)
 	}
 }
@@ -62,8 +67,9 @@ func (p *TCPProxy) controller(conn net.Conn) {
 	ctx, cancel := context.WithCancel(p.ctx)
 	userConn := NewTCPUserConn(NewUserConn(ctx, cancel, p.ProxyServer, conn.RemoteAddr().String()), conn)
 	p.userConnPool.Store(userConn.GetSessionID(), userConn)
+	p.handler.Emit(event.Event{Type: event.SessionOpened, ServiceID: p.ctlMsg.GetServiceID(), SessionID: userConn.GetSessionID(), Addr: conn.RemoteAddr().String()})
 	// 通知客户端新建隧道
-	p.NewTunnel(userConn.GetSessionID())
+	p.NewTunnel(userConn)
 	// 设置连接池超时
 	userConn.ResetTimeout()
 }
@@ -4,9 +4,12 @@ import (
 	"context"
 	"errors"
 	"github.com/sirupsen/logrus"
+	"gnp/pkg/event"
 	"gnp/pkg/message"
+	"gnp/pkg/transport"
 	"gnp/pkg/util"
 	"net"
+	"time"
 )
 
 // UDPProxy 处理 UDP 代理
@@ -15,21 +18,24 @@ type UDPProxy struct {
 	// conn 监听代理端口，处理用户连接
 	conn *net.UDPConn
 	// tunnelData 接收隧道数据的队列
-	tunnelData chan *TunnelData
+	tunnelData *udpQueue[*TunnelData]
 	// tunnelConn UDP 隧道数据连接
-	tunnelConn *net.UDPConn
+	tunnelConn net.PacketConn
 }
 
-func NewUDPProxy(proxyServer *ProxyServer, tunnelConn *net.UDPConn) *UDPProxy {
+func NewUDPProxy(proxyServer *ProxyServer, tunnelConn net.PacketConn) *UDPProxy {
 	return &UDPProxy{
 		ProxyServer: proxyServer,
-		tunnelData:  make(chan *TunnelData, 10),
-		tunnelConn:  tunnelConn,
+		tunnelData: newUDPQueue[*TunnelData](proxyServer.udpQueueSize(), proxyServer.udpQueuePolicy(), func() {
+			proxyServer.udpDrops.add(&proxyServer.udpDrops.tunnelData, "tunnel_data")
+		}),
+		tunnelConn: tunnelConn,
 	}
 }
 
 func (p *UDPProxy) Start() {
-	conn, err := util.CreateListenUDP(p.Config.ServerBind, p.ctlMsg.GetService().GetProxyPort())
+	defer p.finish()
+	conn, err := util.CreateListenUDP(p.GetConfig().ServerBind, p.GetService().GetProxyPort())
 	if err != nil {
 		logrus.Errorf("[%s] proxy listen %v", p.ctlMsg.GetServiceID(), err)
 		return
@@ -40,28 +46,27 @@ func (p *UDPProxy) Start() {
 	go p.CleanUserConn()
 	go p.WatchTunnel()
 	go p.WatchTunnelData()
+	go p.reportDrops()
 	go p.handleConn()
 	<-p.ctx.Done()
 }
 
 func (p *UDPProxy) Close() {
 	_ = p.conn.Close()
-	p.Server.Clean(p.ctlMsg.GetServiceID())
-	logrus.Infof("[%s] close service", p.ctlMsg.ServiceID)
 }
 
 func (p *UDPProxy) handleConn() {
-	for {
-		buf := make([]byte, message.BufDataSize)
-		n, remoteAddr, err := p.conn.ReadFromUDP(buf)
-		if err != nil {
-			if errors.Is(err, net.ErrClosed) {
-				return
-			}
-			logrus.Errorf("[%s] read sessionID proxy %v", p.ctlMsg.GetServiceID(), err)
-			return
-		}
-		go p.controller(buf[:n], remoteAddr)
+	workers := newWorkerPool(func(packet udpPacket) {
+		// 用户数据进入发送队列，需要复制出缓冲区
+		data := make([]byte, packet.n)
+		copy(data, packet.data())
+		p.controller(data, packet.addr.(*net.UDPAddr))
+	})
+	defer workers.close()
+	// 用户地址就是会话 ID，按来源地址分配处理协程
+	err := readPackets(transport.NewBatchConn(p.conn), workers, func([]byte) []byte { return nil })
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		logrus.Errorf("[%s] read sessionID proxy %v", p.ctlMsg.GetServiceID(), err)
 	}
 }
 
@@ -73,11 +78,25 @@ func (p *UDPProxy) controller(data []byte, remoteAddr *net.UDPAddr) {
 		p.handelUserConn(data, userConn.(*UDPUserConn))
 		return
 	}
+	// 停机排空期间不再接受新的用户会话
+	if p.draining.Load() {
+		return
+	}
 	// 如果不存在，把用户连接存入用户连接池，然后通知客户端新建隧道连接
 	cxt, cancel := context.WithCancel(p.ctx)
 	userConn := NewUDPUserConn(NewUserConn(cxt, cancel, p.ProxyServer, sessionID), p.conn, p.tunnelConn, remoteAddr)
+	if p.udpAuth {
+		key, err := message.NewSessionKey()
+		if err != nil {
+			logrus.Errorf("[%s] create session key %v", p.ctlMsg.GetServiceID(), err)
+			cancel()
+			return
+		}
+		userConn.setSessionKey(key)
+	}
 	p.userConnPool.Store(sessionID, userConn)
-	p.NewTunnel(userConn.GetSessionID())
+	p.handler.Emit(event.Event{Type: event.SessionOpened, ServiceID: p.ctlMsg.GetServiceID(), SessionID: sessionID, Addr: remoteAddr.String()})
+	p.NewTunnel(userConn)
 
 	// 设置连接池超时
 	go userConn.waitTimeout()
@@ -91,33 +110,48 @@ func (p *UDPProxy) WatchTunnelData() {
 		select {
 		case <-p.ctx.Done():
 			return
-		case data := <-p.tunnelData:
-			userConn, ok := p.userConnPool.Load(data.dataMsg.GetSessionID())
+		case data := <-p.tunnelData.ch:
+			tunnelData := data.dataMsg.GetTunnelData()
+			userConn, ok := p.userConnPool.Load(tunnelData.GetSessionID())
 			if !ok {
-				logrus.Errorf("[%s] user conn not found sessionID:=%s", p.ctlMsg.GetServiceID(), data.dataMsg.GetSessionID())
-				return
+				logrus.Errorf("[%s] user conn not found sessionID:=%s", p.ctlMsg.GetServiceID(), tunnelData.GetSessionID())
+				continue
 			}
 			_userConn := userConn.(*UDPUserConn)
-			if _userConn.GetSessionID() != data.dataMsg.GetSessionID() {
-				logrus.Warnf("[%s] user sessionID:=%s, tunnel sessionID:=%s", p.ctlMsg.GetServiceID(), _userConn.GetSessionID(), data.dataMsg.GetSessionID())
-				return
+			if _userConn.GetSessionID() != tunnelData.GetSessionID() {
+				logrus.Warnf("[%s] user sessionID:=%s, tunnel sessionID:=%s", p.ctlMsg.GetServiceID(), _userConn.GetSessionID(), tunnelData.GetSessionID())
+				continue
 			}
-			select {
-			case <-_userConn.ctx.Done():
-				return
-			default:
-				_userConn.tunnelCh <- data.dataMsg.Data
+			// 分片收齐后才发送给用户
+			userData, ok := _userConn.reassembler.Add(tunnelData)
+			if !ok {
+				continue
 			}
+			_userConn.tunnelCh.push(_userConn.ctx, userData)
 		}
 	}
 }
 
 func (p *UDPProxy) handelUserConn(data []byte, userConn *UDPUserConn) {
-	select {
-	case <-userConn.ctx.Done():
-		return
-	default:
-		userConn.userCh <- data
+	// 处理协程按会话共用，队列满时按代理服务的策略处理
+	userConn.userCh.push(userConn.ctx, data)
+}
+
+// reportDrops 定时输出队列丢弃的数据包数量，没有新的丢弃时不输出
+func (p *UDPProxy) reportDrops() {
+	t := time.NewTicker(udpDropLogInterval)
+	defer t.Stop()
+	var last int64
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-t.C:
+			if total := p.udpDrops.total(); total > last {
+				logrus.Warnf("[%s] udp queue full policy=%s size=%d dropped %s", p.ctlMsg.GetServiceID(), p.udpQueuePolicy(), p.udpQueueSize(), p.udpDrops)
+				last = total
+			}
+		}
 	}
 }
 
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"gnp/pkg/message"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxUDPQueueSize 客户端可以设置的最大队列长度
+	maxUDPQueueSize = 65536
+	// udpDropLogInterval 输出队列丢弃数量的间隔
+	udpDropLogInterval = time.Second * 10
+)
+
+// udpDropStats 代理服务 UDP 队列丢弃的数据包数量
+type udpDropStats struct {
+	serviceID string
+	// vars 服务端实例的 expvar 指标
+	vars *expvar.Map
+	// userToTunnel 用户数据队列
+	userToTunnel atomic.Int64
+	// tunnelToUser 发送给用户的隧道数据队列
+	tunnelToUser atomic.Int64
+	// tunnelData 代理服务接收隧道数据的队列
+	tunnelData atomic.Int64
+}
+
+func (s *udpDropStats) total() int64 {
+	return s.userToTunnel.Load() + s.tunnelToUser.Load() + s.tunnelData.Load()
+}
+
+func (s *udpDropStats) String() string {
+	return fmt.Sprintf("user_to_tunnel=%d tunnel_to_user=%d tunnel_data=%d", s.userToTunnel.Load(), s.tunnelToUser.Load(), s.tunnelData.Load())
+}
+
+// add 累计丢弃数量，同时更新 expvar 指标
+func (s *udpDropStats) add(counter *atomic.Int64, name string) {
+	counter.Add(1)
+	s.vars.Add(s.serviceID+"."+name, 1)
+}
+
+// udpQueue 按策略处理队列满的情况，消费方直接读取 ch
+type udpQueue[T any] struct {
+	ch     chan T
+	policy string
+	// onDrop 丢弃数据包时调用
+	onDrop func()
+}
+
+// UDPDropVars 各代理服务 UDP 队列丢弃的数据包数量，每个服务端实例独立，没有发布到 expvar，
+// 需要时由调用方发布，gnps 使用 --pprof-server 开启 pprof 服务时可以通过 /debug/vars 查看
+func (s *Server) UDPDropVars() *expvar.Map {
+	return s.udpDropVars
+}
+
+func newUDPQueue[T any](size int, policy string, onDrop func()) *udpQueue[T] {
+	return &udpQueue[T]{
+		ch:     make(chan T, size),
+		policy: policy,
+		onDrop: onDrop,
+	}
+}
+
+// push 放入队列，block 策略等待队列有空位或者 ctx 结束
+func (q *udpQueue[T]) push(ctx context.Context, v T) {
+	switch q.policy {
+	case message.QueueBlock:
+		select {
+		case <-ctx.Done():
+		case q.ch <- v:
+		}
+	case message.QueueDropOldest:
+		for {
+			select {
+			case q.ch <- v:
+				return
+			default:
+			}
+			// 队列满时取出最早的数据包丢弃，和消费方同时取出时重试
+			select {
+			case <-q.ch:
+				q.onDrop()
+			default:
+			}
+		}
+	default:
+		select {
+		case q.ch <- v:
+		default:
+			q.onDrop()
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"github.com/sirupsen/logrus"
+	"net"
+	"time"
+)
+
+// detachServices 控制连接断开后，保留客户端注册的代理服务和已有用户会话，
+// 在恢复等待时间内同一个客户端重新注册时恢复，超时后关闭代理服务
+func (s *Server) detachServices(conn net.Conn) {
+	timeout := time.Second * time.Duration(s.GetConfig().ResumeTimeout)
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	for _, proxy := range s.servicePool {
+		if proxy.ctlConn != conn {
+			continue
+		}
+		if timeout <= 0 || proxy.ctlMsg.GetClientID() == "" {
+			proxy.cancel()
+			continue
+		}
+		logrus.Infof("[%s] client disconnected, wait %s for resume", proxy.ctlMsg.GetServiceID(), timeout)
+		proxy.ctlConn = nil
+		var t *time.Timer
+		t = time.AfterFunc(timeout, func() {
+			s.mx.Lock()
+			expired := proxy.detachTimer == t && proxy.ctlConn == nil
+			s.mx.Unlock()
+			if expired {
+				logrus.Infof("[%s] resume timeout", proxy.ctlMsg.GetServiceID())
+				proxy.cancel()
+			}
+		})
+		proxy.detachTimer = t
+	}
+}
+
+// resumeService 同一个客户端重新注册已存在的代理服务时，把代理服务绑定到新的控制连接
+func (s *Server) resumeService(proxy *ProxyServer, conn net.Conn) {
+	s.mx.Lock()
+	proxy.ctlConn = conn
+	if proxy.detachTimer != nil {
+		proxy.detachTimer.Stop()
+		proxy.detachTimer = nil
+	}
+	s.mx.Unlock()
+	logrus.Infof("[%s] resume service client=%s", proxy.ctlMsg.GetServiceID(), conn.RemoteAddr().String())
+	// 重新通知客户端为等待中的用户连接新建隧道
+	proxy.userConnPool.Range(func(key, value any) bool {
+		userConn := value.(UserConnProvider)
+		if !userConn.IsTunnelAvailable() {
+			proxy.NewTunnel(userConn)
+		}
+		return true
+	})
+}
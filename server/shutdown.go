@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"github.com/sirupsen/logrus"
+	"gnp/pkg/message"
+	"net"
+	"time"
+)
+
+// shutdown 排空停机，停止接受新的控制连接和用户连接，通知客户端服务端即将停机，
+// 等待已有用户会话结束，超过停机超时时间或者 ctx 结束后强制关闭所有连接
+func (s *Server) shutdown(ctx context.Context) error {
+	var err error
+	s.draining.Store(true)
+	timeout := time.Second * time.Duration(s.GetConfig().ShutdownTimeout)
+	logrus.Infof("server draining, shutdown timeout %s", timeout)
+	s.ctlConnPool.Range(func(key, value any) bool {
+		conn := key.(net.Conn)
+		err := s.SendMsg(conn, &message.ControlMessage{Ctl: message.ServerShutdown})
+		if err != nil {
+			logrus.Errorf("send shutdown message %v client=%s", err, conn.RemoteAddr().String())
+		}
+		return true
+	})
+
+	deadline := time.After(timeout)
+	t := time.NewTicker(time.Millisecond * 100)
+	defer t.Stop()
+wait:
+	for {
+		count := s.sessionCount()
+		if count == 0 {
+			logrus.Info("all user sessions finished")
+			break
+		}
+		select {
+		case <-deadline:
+			logrus.Warnf("shutdown timeout, force close %d user sessions", count)
+			break wait
+		case <-ctx.Done():
+			logrus.Warnf("shutdown canceled, force close %d user sessions", count)
+			err = ctx.Err()
+			break wait
+		case <-t.C:
+		}
+	}
+
+	s.cancel()
+	s.ctlConnPool.Range(func(key, value any) bool {
+		_ = key.(net.Conn).Close()
+		return true
+	})
+	s.wg.Wait()
+	return err
+}
+
+// sessionCount 统计所有代理服务的用户会话数量
+func (s *Server) sessionCount() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+	var count int
+	for _, proxy := range s.servicePool {
+		proxy.userConnPool.Range(func(key, value any) bool {
+			count++
+			return true
+		})
+	}
+	return count
+}
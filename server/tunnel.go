@@ -1,6 +1,7 @@
 package server
 
 import (
+	"bufio"
 	"github.com/sirupsen/logrus"
 	"gnp/pkg/message"
 	"gnp/pkg/util"
@@ -9,11 +10,11 @@ import (
 )
 
 type TunnelData struct {
-	remoteAddr *net.UDPAddr
+	remoteAddr net.Addr
 	dataMsg    *message.ControlMessage
 }
 
-func NewTunnelData(dataMsg *message.ControlMessage, remoteAddr *net.UDPAddr) *TunnelData {
+func NewTunnelData(dataMsg *message.ControlMessage, remoteAddr net.Addr) *TunnelData {
 	return &TunnelData{
 		dataMsg:    dataMsg,
 		remoteAddr: remoteAddr,
@@ -22,13 +23,15 @@ func NewTunnelData(dataMsg *message.ControlMessage, remoteAddr *net.UDPAddr) *Tu
 
 type TunnelConn struct {
 	conn       net.Conn
-	remoteAddr *net.UDPAddr
+	remoteAddr net.Addr
 	ctlMsg     *message.ControlMessage
 	oneClose   sync.Once
+	// reader 读取控制消息时已缓冲的隧道连接数据，隧道连接后续的读取都要先经过它
+	reader *bufio.Reader
 }
 
-func NewTunnelConn(conn net.Conn, ctlMsg *message.ControlMessage, remoteAddr *net.UDPAddr) *TunnelConn {
-	logrus.Infof("[%s] new tunnel sessionID:=%s", ctlMsg.GetServiceID(), ctlMsg.GetSessionID())
+func NewTunnelConn(conn net.Conn, ctlMsg *message.ControlMessage, remoteAddr net.Addr) *TunnelConn {
+	logrus.Infof("[%s] new tunnel sessionID:=%s", ctlMsg.GetServiceID(), ctlMsg.GetTunnel().GetSessionID())
 	return &TunnelConn{
 		conn:       conn,
 		ctlMsg:     ctlMsg,
@@ -41,12 +44,12 @@ func (t *TunnelConn) Close() {
 		if t.conn != nil {
 			_ = t.conn.Close()
 		}
-		logrus.Debugf("[%s] close tunnel sessionID:=%s", t.ctlMsg.GetServiceID(), t.ctlMsg.GetSessionID())
+		logrus.Debugf("[%s] close tunnel sessionID:=%s", t.ctlMsg.GetServiceID(), t.GetSessionID())
 	})
 }
 
 func (t *TunnelConn) GetSessionID() string {
-	return t.ctlMsg.GetSessionID()
+	return t.ctlMsg.GetTunnel().GetSessionID()
 }
 
 func (t *TunnelConn) ReSetTimeout(clientTimeout int) {
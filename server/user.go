@@ -3,7 +3,9 @@ package server
 import (
 	"context"
 	"github.com/sirupsen/logrus"
+	"gnp/pkg/event"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,12 +13,20 @@ import (
 type UserConnProvider interface {
 	// IsTunnelAvailable 隧道连接是否可用
 	IsTunnelAvailable() bool
-	// SetTunnelConn 设置隧道连接
-	SetTunnelConn(*TunnelConn)
+	// StartPairing 开始配对隧道连接，已有隧道连接或者正在配对时返回 false
+	StartPairing(*TunnelConn) bool
+	// SetTunnelConn 设置隧道连接，失败时隧道连接不可用
+	SetTunnelConn(*TunnelConn) error
+	// SetConnTimeout 设置会话的空闲超时时间，单位秒
+	SetConnTimeout(int)
 	// GetCreateTime 获取用户连接创建时间
 	GetCreateTime() int64
 	// GetSessionID 获取用户连接的会话 ID
 	GetSessionID() string
+	// GetSessionKey 获取 UDP 会话密钥，不需要签名时为空
+	GetSessionKey() []byte
+	// GetRemoteAddr 获取用户地址
+	GetRemoteAddr() string
 	// Close 关闭用户连接
 	Close()
 	// UserToTunnel 用户数据转发到隧道
@@ -25,6 +35,14 @@ type UserConnProvider interface {
 	TunnelToUser()
 }
 
+// 隧道连接状态
+const (
+	tunnelNone = iota
+	// tunnelPairing 已收到隧道连接，等待新建会话插件通过
+	tunnelPairing
+	tunnelAvailable
+)
+
 // UserConn 处理用户连接
 type UserConn struct {
 	ctx         context.Context
@@ -32,14 +50,18 @@ type UserConn struct {
 	proxyServer *ProxyServer
 	// createTime 用户连接创建时间
 	createTime int64
-	// isTunnelAvailable 隧道连接是否可用
-	isTunnelAvailable bool
 	// sessionID 用户连接的会话 ID
 	sessionID string
 	// oneClose 避免重复关闭用户连接引发异常
 	oneClose sync.Once
-	// tunnelConn 隧道连接信息
+	// tunnelMx 保护隧道连接状态，UDP 处理协程和配对协程会并发访问
+	tunnelMx sync.Mutex
+	// tunnelState 隧道连接状态
+	tunnelState int
+	// tunnelConn 隧道连接信息，开始配对后不再替换，开始转发后可以直接读取
 	tunnelConn *TunnelConn
+	// connTimeout 插件设置的会话空闲超时时间，为 0 时使用代理服务的超时时间
+	connTimeout atomic.Int64
 }
 
 func NewUserConn(ctx context.Context, cancel context.CancelFunc, proxyServer *ProxyServer, sessionID string) *UserConn {
@@ -60,26 +82,65 @@ func (u *UserConn) GetSessionID() string {
 	return u.sessionID
 }
 
-func (u *UserConn) SetTunnelAvailable(x bool) {
-	u.isTunnelAvailable = x
+func (u *UserConn) GetSessionKey() []byte {
+	return nil
 }
 
 func (u *UserConn) IsTunnelAvailable() bool {
-	return u.isTunnelAvailable
+	u.tunnelMx.Lock()
+	defer u.tunnelMx.Unlock()
+	return u.tunnelState == tunnelAvailable
+}
+
+// getTunnelConn 获取正在配对或者已配对的隧道连接，没有时为空
+func (u *UserConn) getTunnelConn() *TunnelConn {
+	u.tunnelMx.Lock()
+	defer u.tunnelMx.Unlock()
+	if u.tunnelState == tunnelNone {
+		return nil
+	}
+	return u.tunnelConn
+}
+
+func (u *UserConn) StartPairing(tunnelConn *TunnelConn) bool {
+	u.tunnelMx.Lock()
+	defer u.tunnelMx.Unlock()
+	if u.tunnelState != tunnelNone {
+		return false
+	}
+	u.tunnelConn = tunnelConn
+	u.tunnelState = tunnelPairing
+	return true
 }
 
 func (u *UserConn) Close() {
 	u.oneClose.Do(func() {
 		u.cancel()
-		if u.isTunnelAvailable {
-			u.tunnelConn.Close()
+		if tunnelConn := u.getTunnelConn(); tunnelConn != nil {
+			tunnelConn.Close()
 		}
 		u.proxyServer.RemoveUserConn(u.GetSessionID())
 		logrus.Debugf("[%s] close userConn sessionID:=%s", u.proxyServer.ctlMsg.GetServiceID(), u.GetSessionID())
+		u.proxyServer.handler.Emit(event.Event{Type: event.SessionClosed, ServiceID: u.proxyServer.ctlMsg.GetServiceID(), SessionID: u.GetSessionID()})
 	})
 }
 
-func (u *UserConn) SetTunnelConn(tunnelConn *TunnelConn) {
+func (u *UserConn) SetConnTimeout(timeout int) {
+	u.connTimeout.Store(int64(timeout))
+}
+
+// getConnTimeout 会话空闲超时时间，插件没有设置时使用代理服务的超时时间
+func (u *UserConn) getConnTimeout() int {
+	if timeout := u.connTimeout.Load(); timeout > 0 {
+		return int(timeout)
+	}
+	return u.proxyServer.connTimeout()
+}
+
+func (u *UserConn) SetTunnelConn(tunnelConn *TunnelConn) error {
+	u.tunnelMx.Lock()
+	defer u.tunnelMx.Unlock()
 	u.tunnelConn = tunnelConn
-	u.SetTunnelAvailable(true)
+	u.tunnelState = tunnelAvailable
+	return nil
 }
@@ -1,8 +1,10 @@
 package server
 
 import (
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"gnp/pkg/message"
+	"gnp/pkg/transport"
 	"gnp/pkg/util"
 	"net"
 )
@@ -12,14 +14,42 @@ type TCPUserConn struct {
 	*UserConn
 	// conn 用户的 TCP 连接
 	conn net.Conn
+	// compressStats 隧道数据压缩统计，代理服务启用压缩时有效
+	compressStats *message.CompressStats
 }
 
 func NewTCPUserConn(userConn *UserConn, conn net.Conn) *TCPUserConn {
 	return &TCPUserConn{UserConn: userConn, conn: conn}
 }
 
+// SetTunnelConn 代理服务启用压缩时，隧道连接使用压缩传输
+func (u *TCPUserConn) SetTunnelConn(tunnelConn *TunnelConn) error {
+	if tunnelConn.reader != nil {
+		// 客户端可能紧跟新建隧道消息发送数据，这部分数据已经读入控制消息的缓冲
+		tunnelConn.conn = transport.NewBufferedConn(tunnelConn.conn, tunnelConn.reader)
+	}
+	if compression := u.proxyServer.GetService().GetCompression(); compression != "" {
+		u.compressStats = new(message.CompressStats)
+		conn, err := message.NewCompressConn(tunnelConn.conn, compression, u.compressStats, u.proxyServer.compressStats)
+		if err != nil {
+			return fmt.Errorf("compress tunnel %v", err)
+		}
+		tunnelConn.conn = conn
+	}
+	return u.UserConn.SetTunnelConn(tunnelConn)
+}
+
+func (u *TCPUserConn) GetRemoteAddr() string {
+	return u.conn.RemoteAddr().String()
+}
+
+func (u *TCPUserConn) Close() {
+	u.UserConn.Close()
+	_ = u.conn.Close()
+}
+
 func (u *TCPUserConn) ResetTimeout() {
-	_ = util.SetReadDeadline(u.conn)
+	_ = util.SetReadDeadline(u.conn)(u.getConnTimeout())
 }
 
 func (u *TCPUserConn) UserToTunnel() {
@@ -36,4 +66,7 @@ func (u *TCPUserConn) TunnelToUser() {
 	if err != nil {
 		logrus.Tracef("[%s] tunnel to user %v", u.proxyServer.ctlMsg.GetServiceID(), err)
 	}
+	if u.compressStats != nil {
+		logrus.Debugf("[%s] compression sessionID:=%s %s", u.proxyServer.ctlMsg.GetServiceID(), u.GetSessionID(), u.compressStats)
+	}
 }
@@ -1,10 +1,14 @@
 package server
 
 import (
+	"bufio"
+	"errors"
 	"github.com/sirupsen/logrus"
 	"gnp/pkg/message"
+	"gnp/pkg/transport"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -14,41 +18,93 @@ type UDPUserConn struct {
 	// 代理端口连接
 	conn *net.UDPConn
 	// udpTunnelConn 隧道连接
-	udpTunnelConn *net.UDPConn
+	udpTunnelConn net.PacketConn
 	// userCh 用户数据队列
-	userCh chan []byte
+	userCh *udpQueue[[]byte]
 	// tunnelCh 隧道数据队列
-	tunnelCh chan []byte
+	tunnelCh *udpQueue[[]byte]
 	// remoteAddr 用户 UDP 地址
 	remoteAddr *net.UDPAddr
 	// timeout 用户连接池超时时间，如果超时则从连接池中删除
 	timeout sync.Map
+	// sessionKey UDP 会话密钥，客户端不支持签名时为空
+	sessionKey []byte
+	// auth 签名发送给客户端的数据包，校验客户端发送的数据包
+	auth *message.PacketAuth
+	// reassembler 重组客户端发送的分片
+	reassembler *message.Reassembler
+	// fragID 发送给客户端的分片数据包 ID
+	fragID atomic.Uint32
 }
 
-func NewUDPUserConn(userConn *UserConn, conn, tunnelConn *net.UDPConn, remoteAddr *net.UDPAddr) *UDPUserConn {
+func NewUDPUserConn(userConn *UserConn, conn *net.UDPConn, tunnelConn net.PacketConn, remoteAddr *net.UDPAddr) *UDPUserConn {
+	p, drops := userConn.proxyServer, userConn.proxyServer.udpDrops
 	return &UDPUserConn{
 		UserConn:      userConn,
-		userCh:        make(chan []byte, 10),
-		tunnelCh:      make(chan []byte, 10),
+		userCh:        newUDPQueue[[]byte](p.udpQueueSize(), p.udpQueuePolicy(), func() { drops.add(&drops.userToTunnel, "user_to_tunnel") }),
+		tunnelCh:      newUDPQueue[[]byte](p.udpQueueSize(), p.udpQueuePolicy(), func() { drops.add(&drops.tunnelToUser, "tunnel_to_user") }),
 		conn:          conn,
 		udpTunnelConn: tunnelConn,
 		remoteAddr:    remoteAddr,
+		reassembler:   message.NewReassembler(time.Second * time.Duration(userConn.proxyServer.GetConfig().UDPFragmentTimeout)),
 	}
 }
 
+func (u *UDPUserConn) setSessionKey(key []byte) {
+	u.sessionKey = key
+	u.auth = message.NewPacketAuth(key, true)
+}
+
+func (u *UDPUserConn) GetSessionKey() []byte {
+	return u.sessionKey
+}
+
+func (u *UDPUserConn) GetRemoteAddr() string {
+	return u.remoteAddr.String()
+}
+
+// verify 校验客户端发送的 UDP 隧道数据包，会话绑定新建隧道数据包的源地址，之后只接收该地址的数据
+func (u *UDPUserConn) verify(msg *message.ControlMessage, remoteAddr net.Addr) error {
+	if u.auth != nil {
+		err := u.auth.Verify(msg)
+		if err != nil {
+			return err
+		}
+	}
+	switch msg.GetCtl() {
+	case message.NewTunnel:
+		if u.getTunnelConn() != nil {
+			return errors.New("tunnel already exists")
+		}
+	case message.NewTunnelData:
+		// 配对期间的数据进入队列，配对完成后发送给用户
+		tunnelConn := u.getTunnelConn()
+		if tunnelConn == nil || tunnelConn.remoteAddr == nil || tunnelConn.remoteAddr.String() != remoteAddr.String() {
+			return errors.New("source address mismatch")
+		}
+	}
+	return nil
+}
+
 func (u *UDPUserConn) UserToTunnel() {
 	defer u.Close()
 	for {
 		select {
 		case <-u.ctx.Done():
 			return
-		case data := <-u.userCh:
-			err := message.WriteToUDP(&message.ControlMessage{
-				Ctl:       message.NewTunnelData,
-				ServiceID: u.proxyServer.ctlMsg.GetServiceID(),
-				SessionID: u.GetSessionID(),
-				Data:      data,
-			}, u.udpTunnelConn, u.tunnelConn.remoteAddr)
+		case data := <-u.userCh.ch:
+			// 取出队列中已有的数据一起发送，减少系统调用
+			batch := [][]byte{data}
+		drain:
+			for len(batch) < udpBatchSize {
+				select {
+				case data := <-u.userCh.ch:
+					batch = append(batch, data)
+				default:
+					break drain
+				}
+			}
+			err := u.writeTunnel(batch)
 			if err != nil {
 				logrus.Tracef("[%s] write to tunnel %v", u.proxyServer.ctlMsg.GetServiceID(), err)
 				return
@@ -58,13 +114,69 @@ func (u *UDPUserConn) UserToTunnel() {
 	}
 }
 
+// writeTunnel 发送用户数据到隧道，UDP 隧道批量发送
+func (u *UDPUserConn) writeTunnel(batch [][]byte) error {
+	var msgs []*message.ControlMessage
+	for _, data := range batch {
+		msg := &message.ControlMessage{
+			Ctl:       message.NewTunnelData,
+			ServiceID: u.proxyServer.ctlMsg.GetServiceID(),
+			Payload: &message.ControlMessage_TunnelData{TunnelData: &message.TunnelData{
+				SessionID: u.GetSessionID(),
+				Data:      data,
+			}},
+		}
+		if u.proxyServer.legacy {
+			message.Downgrade(msg)
+		}
+		if u.tunnelConn.conn != nil {
+			// 通过 TCP 隧道连接传输，按长度分帧，不需要分片和签名
+			err := message.WriteTCP(msg, u.tunnelConn.conn)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if u.proxyServer.udpFragment {
+			mtu := transport.ClampUDPMTU(u.tunnelConn.remoteAddr.Network(), u.proxyServer.GetConfig().UDPMTU)
+			msgs = append(msgs, message.Fragment(msg, mtu, u.fragID.Add(1))...)
+		} else {
+			msgs = append(msgs, msg)
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	// 数据包依次序列化到同一个缓冲区，超出容量时 append 重新分配，已序列化的数据包仍然有效
+	buf := message.GetUDPBuf()
+	defer message.PutUDPBuf(buf)
+	out := (*buf)[:0]
+	packets := make([]transport.Message, 0, len(msgs))
+	for _, msg := range msgs {
+		if u.auth != nil {
+			u.auth.Sign(msg)
+		}
+		start := len(out)
+		var err error
+		out, err = message.AppendUDP(out, msg, u.proxyServer.udpCompact)
+		if err != nil {
+			return err
+		}
+		packets = append(packets, transport.Message{Buffers: [][]byte{out[start:]}, Addr: u.tunnelConn.remoteAddr})
+	}
+	return transport.WriteBatch(u.proxyServer.udpBatch, packets)
+}
+
 func (u *UDPUserConn) TunnelToUser() {
 	defer u.Close()
+	if u.tunnelConn.conn != nil {
+		go u.readTunnel()
+	}
 	for {
 		select {
 		case <-u.ctx.Done():
 			return
-		case data := <-u.tunnelCh:
+		case data := <-u.tunnelCh.ch:
 			_, err := u.conn.WriteToUDP(data, u.remoteAddr)
 			if err != nil {
 				logrus.Tracef("[%s] write to user %v", u.proxyServer.ctlMsg.GetServiceID(), err)
@@ -75,14 +187,37 @@ func (u *UDPUserConn) TunnelToUser() {
 	}
 }
 
+// readTunnel 读取 TCP 隧道连接上按长度分帧的隧道数据
+func (u *UDPUserConn) readTunnel() {
+	defer u.Close()
+	reader := u.tunnelConn.reader
+	if reader == nil {
+		reader = bufio.NewReaderSize(u.tunnelConn.conn, message.ReadBufferSize)
+	}
+	for {
+		msg, err := message.ReadTCP(reader)
+		if err != nil {
+			logrus.Tracef("[%s] read tunnel %v", u.proxyServer.ctlMsg.GetServiceID(), err)
+			return
+		}
+		if msg.GetCtl() != message.NewTunnelData || msg.GetTunnelData().GetSessionID() != u.GetSessionID() {
+			logrus.Warnf("[%s] tunnel data invalid sessionID:=%s", u.proxyServer.ctlMsg.GetServiceID(), u.GetSessionID())
+			continue
+		}
+		u.tunnelCh.push(u.ctx, msg.GetTunnelData().GetData())
+	}
+}
+
 func (u *UDPUserConn) ResetTimeout() {
-	u.timeout.Store(1, time.Now().Unix()+int64(u.proxyServer.Config.ConnTimeout))
+	u.timeout.Store(1, time.Now().Unix()+int64(u.getConnTimeout()))
 }
 
 func (u *UDPUserConn) waitTimeout() {
 	defer u.Close()
-	t := time.NewTicker(time.Second * time.Duration(u.proxyServer.Config.ConnTimeout))
+	t := time.NewTicker(time.Second * time.Duration(u.getConnTimeout()))
+	defer t.Stop()
 	for range t.C {
+		t.Reset(time.Second * time.Duration(u.getConnTimeout()))
 		timeout, _ := u.timeout.Load(1)
 		if time.Now().Unix() > timeout.(int64) {
 			return
@@ -0,0 +1,117 @@
+package server
+
+import (
+	"gnp/pkg/message"
+	"gnp/pkg/transport"
+	"net"
+	"runtime"
+	"sync"
+)
+
+const (
+	// udpBatchSize 每次批量读写的 UDP 数据包数量
+	udpBatchSize = 32
+	// udpWorkerQueueSize 每个处理协程的数据包队列长度
+	udpWorkerQueueSize = 1024
+)
+
+// udpPacket 读取到的 UDP 数据包，处理完成后缓冲区放回缓冲池
+type udpPacket struct {
+	buf  *[]byte
+	n    int
+	addr net.Addr
+}
+
+func (p udpPacket) data() []byte {
+	return (*p.buf)[:p.n]
+}
+
+// workerPool 固定数量的 UDP 数据包处理协程，同一会话的数据包由同一个协程按顺序处理
+type workerPool struct {
+	queues []chan udpPacket
+	wg     sync.WaitGroup
+}
+
+func newWorkerPool(handle func(udpPacket)) *workerPool {
+	w := &workerPool{queues: make([]chan udpPacket, runtime.GOMAXPROCS(0))}
+	for i := range w.queues {
+		queue := make(chan udpPacket, udpWorkerQueueSize)
+		w.queues[i] = queue
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			for p := range queue {
+				handle(p)
+				message.PutUDPBuf(p.buf)
+			}
+		}()
+	}
+	return w
+}
+
+// dispatch 按会话分配处理协程，key 为空时按来源地址分配
+func (w *workerPool) dispatch(key []byte, p udpPacket) {
+	var h uint32
+	if len(key) > 0 {
+		h = hashBytes(key)
+	} else {
+		h = hashAddr(p.addr)
+	}
+	w.queues[h%uint32(len(w.queues))] <- p
+}
+
+// close 关闭处理协程，等待队列中的数据包处理完成
+func (w *workerPool) close() {
+	for _, queue := range w.queues {
+		close(queue)
+	}
+	w.wg.Wait()
+}
+
+// readPackets 批量读取 UDP 数据包交给处理协程，key 返回数据包的会话 ID，读取出错时返回
+func readPackets(conn transport.BatchConn, workers *workerPool, key func([]byte) []byte) error {
+	msgs := make([]transport.Message, udpBatchSize)
+	bufs := make([]*[]byte, udpBatchSize)
+	for i := range msgs {
+		bufs[i] = message.GetUDPBuf()
+		msgs[i].Buffers = [][]byte{*bufs[i]}
+	}
+	defer func() {
+		for _, buf := range bufs {
+			message.PutUDPBuf(buf)
+		}
+	}()
+	for {
+		n, err := conn.ReadBatch(msgs)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			p := udpPacket{buf: bufs[i], n: msgs[i].N, addr: msgs[i].Addr}
+			workers.dispatch(key(p.data()), p)
+			// 缓冲区交给处理协程，重新获取
+			bufs[i] = message.GetUDPBuf()
+			msgs[i].Buffers[0] = *bufs[i]
+		}
+	}
+}
+
+// hashBytes FNV-1a 哈希
+func hashBytes(b []byte) uint32 {
+	h := uint32(2166136261)
+	for _, c := range b {
+		h ^= uint32(c)
+		h *= 16777619
+	}
+	return h
+}
+
+func hashAddr(addr net.Addr) uint32 {
+	if udpAddr, ok := addr.(*net.UDPAddr); ok {
+		return hashBytes(udpAddr.IP) ^ uint32(udpAddr.Port)
+	}
+	if addr == nil {
+		return 0
+	}
+	return hashBytes([]byte(addr.String()))
+}